@@ -0,0 +1,156 @@
+package v1
+
+import (
+	"app/model"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShowAuthorizations 交易授权的生命周期列表：已退款、已撤销的金额、净额、状态、清算或退回的时间和持续天数，
+// 可按 card_number、nickname、status（open、settled、partly_refunded、refunded、reversed）和 start_time、end_time 筛选
+func ShowAuthorizations(c *gin.Context) {
+	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
+	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
+	startTime, _ := strconv.Atoi(c.Query("start_time"))
+	endTime, _ := strconv.Atoi(c.Query("end_time"))
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+
+	rows, total, err := model.GetAuthorizations(c.Query("card_number"), c.Query("nickname"), c.Query("status"), startTime, endTime, loc, pageSize, pageNum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  rows,
+		"msg":   "",
+		"total": total})
+}
+
+// ShowAuthorization 查看一笔交易授权及关联的清算、退款和撤销
+func ShowAuthorization(c *gin.Context) {
+	detail, err := model.GetAuthorization(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code": 404,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": detail,
+		"msg":  ""})
+}
+
+// ShowOrphanRefunds 没有找到原交易授权的交易退款和交易授权撤销，可按 card_number、nickname 筛选
+func ShowOrphanRefunds(c *gin.Context) {
+	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
+	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+
+	rows, total, err := model.GetOrphanRefunds(c.Query("card_number"), c.Query("nickname"), pageSize, pageNum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  rows,
+		"msg":   "",
+		"total": total})
+}
+
+// RelinkAuthorizations 修改关联时间范围的配置后，对所有卡重新关联退款、撤销、清算与交易授权
+func RelinkAuthorizations(c *gin.Context) {
+	count, err := model.RelinkAuthorizations()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": count,
+		"msg":  ""})
+}
+
+// staleAuthorizations 按 days、card_number、nickname 生成超期授权报表，出错时直接返回错误响应
+func staleAuthorizations(c *gin.Context) (*model.StaleAuthorizationReport, bool) {
+	days, _ := strconv.Atoi(c.Query("days"))
+	report, err := model.GetStaleAuthorizations(days, c.Query("card_number"), c.Query("nickname"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return nil, false
+	}
+	return report, true
+}
+
+// ShowStaleAuthorizations 超过 days 天（默认为配置的 StaleDays）仍未清算、也没有退回的交易授权，按卡和昵称汇总
+func ShowStaleAuthorizations(c *gin.Context) {
+	report, ok := staleAuthorizations(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": report,
+		"msg":  ""})
+}
+
+// DownloadStaleAuthorizations 下载超期授权报表 XLSX，参数同 ShowStaleAuthorizations
+func DownloadStaleAuthorizations(c *gin.Context) {
+	report, ok := staleAuthorizations(c)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := model.WriteStaleAuthorizationsXLSX(report, &buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	name := fmt.Sprintf("stale_authorizations_%dd_%s.xlsx", report.Days, report.AsOf.Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name)))
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
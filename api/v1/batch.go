@@ -0,0 +1,139 @@
+package v1
+
+import (
+	"app/model"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShowImportBatches 导入批次列表
+func ShowImportBatches(c *gin.Context) {
+	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
+	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
+	kind := c.Query("kind")
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+
+	batches, total, err := model.GetImportBatches(kind, pageSize, pageNum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  batches,
+		"msg":   "",
+		"total": total})
+}
+
+// ShowImportBatch 查看导入批次及其导入的行
+func ShowImportBatch(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
+	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+
+	batch, err := model.GetImportBatch(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code": 404,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+
+	var rows interface{}
+	var total int64
+	if batch.Kind == model.ImportKindCard {
+		rows, total, err = model.GetBatchTransactions(batch.ID, pageSize, pageNum)
+	} else {
+		rows, total, err = model.GetBatchTransactionRecords(batch.ID, pageSize, pageNum)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"batch": batch,
+		"data":  rows,
+		"msg":   "",
+		"total": total})
+}
+
+// RollbackImportBatch 回滚导入批次
+func RollbackImportBatch(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	batch, err := model.RollbackImportBatch(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": batch,
+		"msg":  "批次已回滚"})
+}
+
+// DownloadImportRejects 下载批次的拒绝行 XLSX 供核对，不能代替源文件重新上传，见 model.WriteRejectsXLSX
+func DownloadImportRejects(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var buf bytes.Buffer
+	if err := model.WriteRejectsXLSX(uint(id), &buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	name := fmt.Sprintf("rejects_%d.xlsx", id)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name)))
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
+
+// ShowTransactionHistory 查看一笔虚拟卡交易在重新导入时的修改历史
+func ShowTransactionHistory(c *gin.Context) {
+	changes, err := model.GetTransactionChanges(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  changes,
+		"msg":   "",
+		"total": len(changes)})
+}
@@ -29,7 +29,11 @@ func ShowFile1(c *gin.Context) {
 	// 	pageNum = 1
 	// }
 
-	result, err, total := model.GetTransactions(pageSize, pageNum, cardNumber, transactionType, startTime ,endTime, is_judge,set)
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
+	result, err, total := model.GetTransactions(pageSize, pageNum, cardNumber, transactionType, startTime ,endTime, loc, is_judge,set)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 500,
@@ -68,7 +72,11 @@ func ShowFile2(c *gin.Context) {
 	// 	pageNum = 1
 	// }
 
-	result, err, total := model.GetTransactionRecords(pageSize, pageNum, Account, PaymentMethod,startTime,endTime, set)
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
+	result, err, total := model.GetTransactionRecords(pageSize, pageNum, Account, PaymentMethod,startTime,endTime, loc, set)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{
@@ -95,7 +103,11 @@ func ShowVirtualCardDataByaccount(c *gin.Context) {
 	endTime, _ := strconv.Atoi(c.Query("end_time"))
 	// 使用BindJSON方法解析请求体到req变量中
 	
-	result, err := model.CalFBbyaccount(Account,PaymentMethod,startTime,endTime)
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
+	result, currency, err := model.CalFBbyaccount(Account,PaymentMethod,startTime,endTime, loc, reportCurrency(c))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"code": 500,
@@ -106,9 +118,10 @@ func ShowVirtualCardDataByaccount(c *gin.Context) {
 	} else {
 		c.JSON(
 			http.StatusOK, gin.H{
-				"status": 200,
-				"data":   result,
-				"msg":    err,
+				"status":   200,
+				"data":     result,
+				"currency": currency,
+				"msg":      err,
 			},
 		)
 	}
@@ -171,6 +184,15 @@ func UpdateTransactionRecord(c *gin.Context) {
 		return
 	}
 	err := model.UpdateTransactionRecord(req.TransactionID, req.IsTicked, req.Note)
+	if err != nil {
+		// 已结账期间的账单不能修改
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error(),
+		})
+		return
+	}
 
 	c.JSON(
 		http.StatusOK, gin.H{
@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"app/model"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reportCurrency 读取汇总接口的 convert、currency 参数，见 model.ReportCurrency
+func reportCurrency(c *gin.Context) string {
+	convert, _ := strconv.ParseBool(c.Query("convert"))
+	return model.ReportCurrency(convert, c.Query("currency"))
+}
+
+// UploadFxRates 上传汇率 CSV（表单字段 f1），列为 Date、From、To、Rate
+func UploadFxRates(c *gin.Context) {
+	dst, _, ok := saveUpload(c, os.TempDir(), "csv")
+	if !ok {
+		return
+	}
+	defer os.Remove(dst)
+
+	n, err := model.ImportFxRatesFromCSV(dst)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": n,
+		"msg":  "汇率已导入"})
+}
+
+// AddFxRates 以 JSON 数组提交汇率，如 [{"date":"2024-05-01","from":"EUR","to":"USD","rate":"1.0712"}]
+func AddFxRates(c *gin.Context) {
+	var rates []model.FxRate
+	if err := c.ShouldBindJSON(&rates); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	n, err := model.SaveFxRates(rates)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": n,
+		"msg":  "汇率已保存"})
+}
+
+// ShowFxRates 汇率列表，currency 参数只显示涉及该币种的汇率
+func ShowFxRates(c *gin.Context) {
+	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
+	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+
+	rates, total, err := model.GetFxRates(c.Query("currency"), pageSize, pageNum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  rates,
+		"msg":   "",
+		"total": total})
+}
@@ -0,0 +1,65 @@
+package v1
+
+import (
+	"app/model"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShowImportJob 查询导入任务的进度和结果
+func ShowImportJob(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	job, err := model.GetImportJob(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code": 404,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+
+	var batch *model.ImportBatch
+	if job.BatchID != 0 {
+		if b, err := model.GetImportBatch(job.BatchID); err == nil {
+			batch = &b
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  job,
+		"batch": batch,
+		"msg":   job.Error})
+}
+
+// ShowImportJobs 导入任务列表
+func ShowImportJobs(c *gin.Context) {
+	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
+	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
+	status := c.Query("status")
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+
+	jobs, total, err := model.GetImportJobs(status, pageSize, pageNum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  jobs,
+		"msg":   "",
+		"total": total})
+}
@@ -0,0 +1,73 @@
+package v1
+
+import (
+	"app/middleware"
+	"app/model"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShowLedgerRules 余额规则列表
+func ShowLedgerRules(c *gin.Context) {
+	rules, err := model.GetLedgerRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": rules,
+		"msg":  ""})
+}
+
+// EditLedgerRule 新增或修改一种交易类型的余额规则，
+// 如 {"transaction_type":"交易授权","effect":"subtract","apply_fee":true}
+func EditLedgerRule(c *gin.Context) {
+	var rule model.LedgerRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	saved, err := model.SaveLedgerRule(rule, middleware.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": saved,
+		"msg":  "余额规则已保存"})
+}
+
+// ShowVccBalanceBreakdown 一张卡的余额及每条余额规则的贡献
+func ShowVccBalanceBreakdown(c *gin.Context) {
+	startTime, _ := strconv.Atoi(c.Query("start_time"))
+	endTime, _ := strconv.Atoi(c.Query("end_time"))
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
+	breakdown, err := model.VccBalanceBreakdown(c.Query("account"), c.Query("id"), startTime, endTime, loc, reportCurrency(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": breakdown,
+		"msg":  ""})
+}
@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"app/middleware"
+	"app/model"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PeriodLockRequest 结账或解锁的请求体
+type PeriodLockRequest struct {
+	Account string `json:"account"` // 为空表示所有账户
+	Month   string `json:"month"`   // 2006-01
+	Reason  string `json:"reason"`
+}
+
+// ShowPeriodLocks 已结账的期间，传 account 时包含该账户和所有账户的锁定
+func ShowPeriodLocks(c *gin.Context) {
+	locks, err := model.GetPeriodLocks(c.Query("account"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": locks,
+		"msg":  ""})
+}
+
+// ShowPeriodLockEvents 结账和解锁记录，可按 account、month 筛选
+func ShowPeriodLockEvents(c *gin.Context) {
+	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
+	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+
+	events, total, err := model.GetPeriodLockEvents(c.Query("account"), c.Query("month"), pageSize, pageNum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  events,
+		"msg":   "",
+		"total": total})
+}
+
+// LockPeriod 结账：锁定广告账户某月，之后导入和编辑不能再修改该期间的数据
+func LockPeriod(c *gin.Context) {
+	var req PeriodLockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	lock, err := model.LockPeriod(req.Account, req.Month, req.Reason, middleware.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": lock,
+		"msg":  ""})
+}
+
+// UnlockPeriod 解锁已结账的期间，只有管理员可以操作
+func UnlockPeriod(c *gin.Context) {
+	var req PeriodLockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	if err := model.UnlockPeriod(req.Account, req.Month, req.Reason, middleware.CurrentUser(c)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": "",
+		"msg":  "已解锁"})
+}
@@ -0,0 +1,183 @@
+package v1
+
+import (
+	"app/middleware"
+	"app/model"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ShowReconciliations 匹配列表，可按 settlement_id、record_id、account 筛选，revoked=true 时包含已撤销的匹配
+func ShowReconciliations(c *gin.Context) {
+	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
+	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
+	revoked, _ := strconv.ParseBool(c.Query("revoked"))
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
+
+	rows, total, err := model.GetReconciliations(c.Query("settlement_id"), c.Query("record_id"), c.Query("account"), revoked, pageSize, pageNum)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code":  200,
+		"data":  rows,
+		"msg":   "",
+		"total": total})
+}
+
+// ShowReconciliation 查看一条匹配及对应的交易清算和账单
+func ShowReconciliation(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	detail, err := model.GetReconciliation(uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"code": 404,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": detail,
+		"msg":  ""})
+}
+
+// ManualMatchRequest 人工匹配的请求体
+type ManualMatchRequest struct {
+	SettlementID string   `json:"settlement_id"` // 交易清算的交易ID
+	RecordIDs    []string `json:"record_ids"`    // 账单的交易ID，多笔账单合并为一笔清算时传多个
+}
+
+// AddReconciliation 人工把账单匹配到交易清算
+func AddReconciliation(c *gin.Context) {
+	var req ManualMatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	rows, err := model.MatchManually(req.SettlementID, req.RecordIDs, middleware.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": rows,
+		"msg":  "已匹配"})
+}
+
+// DeleteReconciliation 撤销一条匹配，之后自动核对不会再匹配这一对
+func DeleteReconciliation(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	match, err := model.Unmatch(uint(id), middleware.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": match,
+		"msg":  "匹配已撤销"})
+}
+
+// Reconcile 对未核对的账单和交易清算重新核对，可按 account 和 start_time、end_time 限定范围，返回新增的匹配
+func Reconcile(c *gin.Context) {
+	startTime, _ := strconv.Atoi(c.Query("start_time"))
+	endTime, _ := strconv.Atoi(c.Query("end_time"))
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
+	summary, err := model.ReconcileUnmatched(c.Query("account"), startTime, endTime, loc, middleware.CurrentUser(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": summary,
+		"msg":  ""})
+}
+
+// reconcileExceptions 按 account、year、month、tz 生成核对差异报表，出错时直接返回错误响应
+func reconcileExceptions(c *gin.Context) (*model.ExceptionReport, bool) {
+	year, _ := strconv.Atoi(c.Query("year"))
+	month, _ := strconv.Atoi(c.Query("month"))
+	loc, ok := userLocation(c)
+	if !ok {
+		return nil, false
+	}
+	report, err := model.GetExceptionReport(c.Query("account"), year, month, loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return nil, false
+	}
+	return report, true
+}
+
+// ShowReconcileExceptions 广告账户某月的核对差异：未匹配的账单、未匹配的交易清算、金额不一致的匹配和两边合计
+func ShowReconcileExceptions(c *gin.Context) {
+	report, ok := reconcileExceptions(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": report,
+		"msg":  ""})
+}
+
+// DownloadReconcileExceptions 下载核对差异报表 XLSX，参数同 ShowReconcileExceptions
+func DownloadReconcileExceptions(c *gin.Context) {
+	report, ok := reconcileExceptions(c)
+	if !ok {
+		return
+	}
+	var buf bytes.Buffer
+	if err := model.WriteExceptionReportXLSX(report, &buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	name := fmt.Sprintf("exceptions_%s.xlsx", report.Month)
+	if report.Account != "" {
+		name = fmt.Sprintf("exceptions_%s_%s.xlsx", report.Account, report.Month)
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name)))
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
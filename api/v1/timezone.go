@@ -0,0 +1,23 @@
+package v1
+
+import (
+	"app/model"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userLocation 读取 tz 参数（IANA 时区名称，如 Asia/Shanghai），start_time、end_time 按该时区划分日期；
+// 未传时为配置的默认时区，时区无效时直接返回错误响应
+func userLocation(c *gin.Context) (*time.Location, bool) {
+	loc, err := model.UserLocation(c.Query("tz"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": "",
+			"msg":  err.Error()})
+		return nil, false
+	}
+	return loc, true
+}
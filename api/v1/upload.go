@@ -1,89 +1,222 @@
 package v1
 
 import (
+	"app/importer"
+	"app/middleware"
 	"app/model"
 	"fmt"
 	"net/http"
-	"github.com/gin-gonic/gin"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
-func Upload1(c *gin.Context) {
+// saveUpload 把表单字段 f1 中的文件保存到 dir 目录，并返回原始文件名、上传人及导入模式
+// （best_effort=true 时写入可以导入的行，否则有被拒绝的行就整体不写入）；
+// 扩展名不符或保存失败时直接返回错误响应
+func saveUpload(c *gin.Context, dir string, ext string) (string, model.ImportOptions, bool) {
+	var opts model.ImportOptions
 	file, err := c.FormFile("f1")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 500,
 			"data": "",
 			"msg":  err.Error()})
+		return "", opts, false
 	}
-	if file.Filename[len(file.Filename)-4:] != "xlsx" {
+	if !strings.HasSuffix(strings.ToLower(file.Filename), "."+ext) {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 500,
 			"data": "",
-			"msg":  "非xlsx格式"})
+			"msg":  fmt.Sprintf("非%s格式", ext)})
+		return "", opts, false
 	}
-	dst := fmt.Sprintf("files1/%s", file.Filename)
+	dst := fmt.Sprintf("%s/%s_%s", dir, time.Now().Format("20060102150405"), filepath.Base(file.Filename))
 	// 上传文件到指定的目录
-	err = c.SaveUploadedFile(file, dst)
+	if err = c.SaveUploadedFile(file, dst); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return "", opts, false
+	}
+	opts.FileName = file.Filename
+	// 上传人只取自登录令牌，未登录时记为匿名，不接受请求中自报的名字
+	opts.Uploader = middleware.CurrentUser(c)
+	if opts.Uploader == "" {
+		opts.Uploader = "anonymous"
+	}
+	opts.BestEffort, _ = strconv.ParseBool(c.DefaultPostForm("best_effort", c.Query("best_effort")))
+	return dst, opts, true
+}
+
+// cardImporter 按 provider 参数选择虚拟卡供应商的解析器，未指定时为 model.DefaultCardProvider；
+// 供应商未注册时直接返回错误响应
+func cardImporter(c *gin.Context) (string, importer.CardImporter, bool) {
+	provider := c.DefaultPostForm("provider", c.DefaultQuery("provider", model.DefaultCardProvider))
+	imp, err := importer.Lookup(provider)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": importer.Providers(),
+			"msg":  err.Error()})
+		return "", nil, false
+	}
+	return provider, imp, true
+}
+
+// Upload1 上传虚拟卡文件，登记为后台导入任务后立即返回任务ID
+func Upload1(c *gin.Context) {
+	provider, imp, ok := cardImporter(c)
+	if !ok {
+		return
+	}
+	dst, opts, ok := saveUpload(c, "files1", imp.Ext())
+	if !ok {
+		return
+	}
 
+	job, err := model.EnqueueImportJob(model.ImportKindCard, provider, dst, opts)
 	if err != nil {
+		_ = os.Remove(dst)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 500,
 			"data": "",
 			"msg":  err.Error()})
-	} else {
-		err1 := model.ImportTransactionsFromXLSX(dst) // 假设 db 是你的数据库连接
-		if err1 != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"code": 500,
-				"data": "",
-				"msg":  err})
-		}else {
-			c.JSON(http.StatusOK, gin.H{
-			"code": 200,
-			"data": "",
-			"msg":  "成功导入虚拟卡文件"})
-		}
-		_ = os.Remove(dst)  
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": job,
+		"msg":  "虚拟卡文件已提交导入"})
 }
 
+// billingImporter 按 platform 参数选择广告平台的账单解析器，未指定时为 model.DefaultBillingPlatform；
+// 平台未注册时直接返回错误响应
+func billingImporter(c *gin.Context) (string, importer.BillingImporter, bool) {
+	platform := c.DefaultPostForm("platform", c.DefaultQuery("platform", model.DefaultBillingPlatform))
+	imp, err := importer.LookupBilling(platform)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 400,
+			"data": importer.Platforms(),
+			"msg":  err.Error()})
+		return "", nil, false
+	}
+	return platform, imp, true
+}
+
+// Upload2 上传广告平台账单文件（FB / Google Ads / TikTok Ads），登记为后台导入任务后立即返回任务ID
 func Upload2(c *gin.Context) {
-	file, err := c.FormFile("f1")
+	platform, imp, ok := billingImporter(c)
+	if !ok {
+		return
+	}
+	dst, opts, ok := saveUpload(c, "files2", imp.Ext())
+	if !ok {
+		return
+	}
+
+	job, err := model.EnqueueImportJob(model.ImportKindBilling, platform, dst, opts)
 	if err != nil {
+		_ = os.Remove(dst)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 500,
 			"data": "",
 			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": job,
+		"msg":  "账单文件已提交导入"})
+}
+
+// Preview1 预览虚拟卡文件的导入结果，不写入数据库
+func Preview1(c *gin.Context) {
+	_, imp, ok := cardImporter(c)
+	if !ok {
+		return
+	}
+	dst, _, ok := saveUpload(c, "files1", imp.Ext())
+	if !ok {
+		return
 	}
-	if file.Filename[len(file.Filename)-3:] != "csv" {
+	defer os.Remove(dst)
+
+	reader, err := imp.Open(dst)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 500,
 			"data": "",
-			"msg":  "非csv格式"})
+			"msg":  err.Error()})
+		return
 	}
-	dst := fmt.Sprintf("files2/%s", file.Filename)
-	// 上传文件到指定的目录
-	err = c.SaveUploadedFile(file, dst)
+	defer reader.Close()
+	preview, err := model.PreviewTransactions(reader)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": preview,
+		"msg":  ""})
+}
+
+// Preview2 预览广告平台账单文件的导入结果，不写入数据库
+func Preview2(c *gin.Context) {
+	platform, imp, ok := billingImporter(c)
+	if !ok {
+		return
+	}
+	dst, _, ok := saveUpload(c, "files2", imp.Ext())
+	if !ok {
+		return
+	}
+	defer os.Remove(dst)
 
+	billing, err := imp.Read(dst)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"code": 500,
 			"data": "",
 			"msg":  err.Error()})
-	} else {
-		err1 := model.ImportTransactionRecordFromCSV(dst) // 假设 db 是你的数据库连接
-		if err1 != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"code": 500,
-				"data": "",
-				"msg":  err1.Error()})
-		} else {
-			c.JSON(http.StatusOK, gin.H{
-			"code": 200,
+		return
+	}
+	preview, err := model.PreviewTransactionRecords(billing, platform)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code": 500,
 			"data": "",
-			"msg":  "成功导入FB文件"})
-		}
-		_ = os.Remove(dst)
+			"msg":  err.Error()})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": preview,
+		"msg":  ""})
+}
+
+// ShowCardProviders 已注册的虚拟卡供应商，上传时作为 provider 参数
+func ShowCardProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": importer.Providers(),
+		"msg":  ""})
+}
+
+// ShowBillingPlatforms 已注册的广告平台，上传账单时作为 platform 参数
+func ShowBillingPlatforms(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code": 200,
+		"data": importer.Platforms(),
+		"msg":  ""})
 }
@@ -2,6 +2,7 @@ package v1
 
 import (
 	"app/model"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -54,10 +55,8 @@ import (
 // 	)
 // }
 
-type PaginationResult struct {  
-    CurrentPage map[string]struct{ Balance, Deplete float64 }  
-}  
-
+// ShowVccBalanceAndDeplete 广告账户下各卡的余额和总消耗。sort 为 balance、deplete、last_activity，
+// order=desc 时降序；balance_below、balance_above、deplete_below、deplete_above 按金额筛选，排序筛选后再分页
 func ShowVccBalanceAndDeplete(c *gin.Context) {
 	pageSize, _ := strconv.Atoi(c.Query("pagesize"))
 	pageNum, _ := strconv.Atoi(c.Query("pagenum"))
@@ -65,30 +64,104 @@ func ShowVccBalanceAndDeplete(c *gin.Context) {
 	endTime, _ := strconv.Atoi(c.Query("end_time"))
 	fb_id := c.Query("account")
 	id := c.Query("id")
+	switch {
+	case pageSize >= 100:
+		pageSize = 100
+	case pageSize <= 0:
+		pageSize = 10
+	}
+	if pageNum <= 0 {
+		pageNum = 1
+	}
 	var IDs []string
+	var err error
 	if fb_id != "" && id == ""{
-		_, IDs, _ = model.ShowFBID(fb_id)
+		_, IDs, err = model.ShowFBID(fb_id)
 		
 	} else if fb_id != "" && id != ""{
 		// IDs, _ = model.ShowVccID()
-		IDs = append([]string{id})
+		IDs = []string{id}
 	} else if fb_id == "" && id == ""{
 		// IDs, _ = model.ShowVccID()
 		fb_id = model.ShowFB1()
-		_, IDs, _ = model.ShowFBID(fb_id)
+		_, IDs, err = model.ShowFBID(fb_id)
 	}	
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0,
+		})
+		return
+	}
 	
-	paginationResult, err, total := model.ShowVccBalanceAndDepletes(fb_id, IDs, pageSize, pageNum, startTime, endTime)
+	loc, ok := userLocation(c)
+	if !ok {
+		return
+	}
+	query := model.VccSummaryQuery{
+		Sort:     c.Query("sort"),
+		Desc:     c.Query("order") == "desc",
+		PageSize: pageSize,
+		PageNum:  pageNum,
+	}
+	var err1, err2, err3, err4 error
+	query.BalanceBelow, err1 = queryMoney(c, "balance_below")
+	query.BalanceAbove, err2 = queryMoney(c, "balance_above")
+	query.DepleteBelow, err3 = queryMoney(c, "deplete_below")
+	query.DepleteAbove, err4 = queryMoney(c, "deplete_above")
+	if err = errors.Join(err1, err2, err3, err4); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":  400,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0,
+		})
+		return
+	}
+	summaries, total, err := model.GetVccSummaries(fb_id, IDs, startTime, endTime, loc, reportCurrency(c), query)
+	if errors.Is(err, model.ErrInvalidVccSort) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code":  400,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"code":  500,
+			"data":  "",
+			"msg":   err.Error(),
+			"total": 0,
+		})
+		return
+	}
 	
 		c.JSON(http.StatusOK, gin.H{
 			"code": 200,
-			"data": paginationResult,
-			"msg":  err,
+			"data": summaries,
+			"msg":  "",
 			"total": total,
 		})
 	
 }
 
+// queryMoney 读取可选的金额参数，没有传时返回 nil
+func queryMoney(c *gin.Context, param string) (*model.Money, error) {
+	v := c.Query(param)
+	if v == "" {
+		return nil, nil
+	}
+	amount, err := model.ParseMoney(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", param, err)
+	}
+	return &amount, nil
+}
+
 func ShowVccID(c *gin.Context) {
 	IDs, err := model.ShowVccID()
 	if err != nil {
@@ -139,6 +212,9 @@ type VccDepleteRequest struct {
 	Year       int    `json:"year"`
 	Month      int    `json:"month"`
 	CardNumber string `json:"card_number"` // 注意这里使用了card_number而不是vccid
+	Convert    bool   `json:"convert"`     // 是否换算为 Currency（为空时为本位币）
+	Currency   string `json:"currency"`
+	Timezone   string `json:"tz"`          // 按哪个时区划分月份，为空时为配置的默认时区
 }
 
 func ShowVccDepleteByDate(c *gin.Context) {
@@ -153,13 +229,31 @@ func ShowVccDepleteByDate(c *gin.Context) {
 		return
 	}
 
-	Deplete, err := model.CalVccDepleteByDate(req.Year, req.Month, req.CardNumber)
-	formattedDeplete := fmt.Sprintf("%.3f", Deplete)
+	loc, err := model.UserLocation(req.Timezone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error(),
+		})
+		return
+	}
+	Deplete, currency, err := model.CalVccDepleteByDate(req.Year, req.Month, req.CardNumber, loc, model.ReportCurrency(req.Convert, req.Currency))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"code": 500,
+			"data": "",
+			"msg":  err.Error(),
+		})
+		return
+	}
+	formattedDeplete := Deplete.String()
 	c.JSON(
 		http.StatusOK, gin.H{
-			"code": 200,
-			"data": formattedDeplete,
-			"msg":  err,
+			"code":     200,
+			"data":     formattedDeplete,
+			"currency": currency,
+			"msg":      err,
 		},
 	)
 }
@@ -0,0 +1,152 @@
+package importer
+
+import (
+	"app/model"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// columnarBilling 按表头识别列的账单 CSV：标题行之前可以有若干说明行，
+// 之后每行一条记录，没有 FB 账单那样的账户区块和总计行
+type columnarBilling struct {
+	name    string   // 平台名称，用于错误信息
+	columns []column // 至少包含 Date、TransactionID、PaymentMethod、Amount，可选 Account、Currency
+	// dateLayouts 日期列可能的格式，按顺序尝试
+	dateLayouts []string
+	// accountPrefixes 标题行之前形如 "Customer ID: 123-456-7890" 的说明行前缀，
+	// 文件没有账户列时账单行都归属于该账户
+	accountPrefixes []string
+	// charge 是否为卡上的扣款行，其余行（广告花费、税费、调整等）不导入
+	charge func(h columnIndex, row []string) bool
+}
+
+func (b columnarBilling) Ext() string { return "csv" }
+
+// Read 读取账单 CSV。平台导出的扣款金额可能是负数（表示账户收到的付款），统一取绝对值，
+// 与 FB 账单一样以卡上扣款的正数金额匹配交易清算
+func (b columnarBilling) Read(filePath string) (*model.BillingFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // 允许字段数量不一致
+	reader.LazyQuotes = true
+
+	result := &model.BillingFile{}
+	var h columnIndex
+	var headerErr error
+	account := ""
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rowNum, _ := reader.FieldPos(0)
+		if len(strings.Join(record, "")) == 0 {
+			continue // 空行
+		}
+
+		if h == nil {
+			// 标题行之前的说明行
+			if a, ok := b.preambleAccount(record); ok {
+				account = a
+				continue
+			}
+			if h, err = mapHeader(record, b.columns); err != nil {
+				if headerErr == nil {
+					headerErr = err
+				}
+				continue
+			}
+			result.Header = record
+			continue
+		}
+
+		if !b.charge(h, record) {
+			continue
+		}
+		trans, warning, err := b.record(h, record, account)
+		if err != nil {
+			result.Rejects = append(result.Rejects, model.RejectedRow{Row: rowNum, Raw: record, Reason: err.Error()})
+			continue
+		}
+		if warning != "" {
+			result.Rejects = append(result.Rejects, model.RejectedRow{Row: rowNum, Raw: record, Reason: warning, Warning: true})
+		}
+		result.Rows = append(result.Rows, model.ParsedRecord{Row: rowNum, Raw: record, Section: -1, TransactionRecord: trans})
+	}
+	if h == nil {
+		if headerErr == nil {
+			headerErr = errors.New("文件为空")
+		}
+		return nil, fmt.Errorf("%s账单未找到标题行: %v", b.name, headerErr)
+	}
+	return result, nil
+}
+
+// preambleAccount 说明行中的账户，如 "Customer ID: 123-456-7890"
+func (b columnarBilling) preambleAccount(record []string) (string, bool) {
+	line := strings.TrimSpace(strings.TrimPrefix(record[0], "\ufeff"))
+	for _, prefix := range b.accountPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			account := strings.TrimSpace(strings.TrimPrefix(line, prefix))
+			if account == "" && len(record) > 1 {
+				account = strings.TrimSpace(record[1]) // "Customer ID:",123-456-7890
+			}
+			return account, true
+		}
+	}
+	return "", false
+}
+
+// record 解析一个扣款行，交易ID、账户、日期或金额无效时返回错误
+func (b columnarBilling) record(h columnIndex, row []string, account string) (trans model.TransactionRecord, warning string, err error) {
+	trans.TransactionID = h.get(row, "TransactionID")
+	if trans.TransactionID == "" {
+		return trans, "", errors.New("交易ID为空")
+	}
+	trans.Account = account
+	if v := h.get(row, "Account"); v != "" {
+		trans.Account = v
+	}
+	if trans.Account == "" {
+		return trans, "", errors.New("缺少广告账户")
+	}
+	date := h.get(row, "Date")
+	if trans.Date, err = parseDateLayouts(date, b.dateLayouts); err != nil {
+		return trans, "", fmt.Errorf("日期无法解析: %q", date)
+	}
+	amount, err := model.ParseAmount(h.get(row, "Amount"))
+	if err != nil {
+		return trans, "", fmt.Errorf("金额无法解析: %q", h.get(row, "Amount"))
+	}
+	trans.Amount = amount.Abs()
+	paymentMethod := h.get(row, "PaymentMethod")
+	if len(paymentMethod) < 4 {
+		warning = fmt.Sprintf("Payment Method 不足4位: %q", paymentMethod)
+	}
+	trans.PaymentMethod = model.LastFour(paymentMethod)
+	trans.Currency = h.get(row, "Currency")
+	return trans, warning, nil
+}
+
+// parseDateLayouts 按 layouts 依次尝试解析日期，带时间时取文件中所写的日期，即平台时区的日期
+func parseDateLayouts(s string, layouts []string) (model.Date, error) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return model.Date(t.Format(model.DateLayout)), nil
+		}
+	}
+	return "", fmt.Errorf("failed to parse date: %q", s)
+}
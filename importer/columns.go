@@ -0,0 +1,55 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+)
+
+// column 描述导出文件中的一列：对应的字段、可识别的表头写法以及是否必需
+type column struct {
+	Field    string
+	Headers  []string
+	Required bool
+}
+
+// columnIndex 字段名到列下标的映射
+type columnIndex map[string]int
+
+// mapHeader 根据标题行定位各字段所在的列，未知的列直接忽略，
+// 缺少必需列时返回列出所有缺失列的错误
+func mapHeader(header []string, columns []column) (columnIndex, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if _, ok := index[name]; !ok && name != "" {
+			index[name] = i
+		}
+	}
+
+	h := make(columnIndex, len(columns))
+	var missing []string
+	for _, col := range columns {
+		for _, name := range col.Headers {
+			if i, ok := index[name]; ok {
+				h[col.Field] = i
+				break
+			}
+		}
+		if _, ok := h[col.Field]; !ok && col.Required {
+			missing = append(missing, strings.Join(col.Headers, "/"))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, errors.New("缺少必需列: " + strings.Join(missing, ", "))
+	}
+	return h, nil
+}
+
+// get 取出某字段在该行中的值，列不存在或该行较短时返回空字符串
+func (h columnIndex) get(row []string, field string) string {
+	i, ok := h[field]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[i])
+}
@@ -0,0 +1,89 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testColumns = []column{
+	{Field: "ID", Headers: []string{"交易ID", "交易编号"}, Required: true},
+	{Field: "Time", Headers: []string{"交易时间"}, Required: true},
+	{Field: "Note", Headers: []string{"备注"}},
+}
+
+func TestMapHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		want    columnIndex
+		missing []string // 错误信息中应列出的缺失列
+	}{
+		{
+			name:   "按表头文字定位，忽略列顺序和未知列",
+			header: []string{"其他", "交易时间", "交易ID", "备注"},
+			want:   columnIndex{"ID": 2, "Time": 1, "Note": 3},
+		},
+		{
+			name:   "别名、BOM 和首尾空格",
+			header: []string{"\ufeff交易编号", " 交易时间 "},
+			want:   columnIndex{"ID": 0, "Time": 1},
+		},
+		{
+			name:   "同名列取第一列",
+			header: []string{"交易ID", "交易时间", "交易ID"},
+			want:   columnIndex{"ID": 0, "Time": 1},
+		},
+		{
+			name:   "按 Headers 的顺序优先匹配靠前的写法",
+			header: []string{"交易编号", "交易ID", "交易时间"},
+			want:   columnIndex{"ID": 1, "Time": 2},
+		},
+		{
+			name:    "列出所有缺失的必需列，可选列缺失不报错",
+			header:  []string{"备注"},
+			missing: []string{"交易ID/交易编号", "交易时间"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mapHeader(tt.header, testColumns)
+			if tt.missing != nil {
+				if err == nil {
+					t.Fatalf("mapHeader() = %v, want error", got)
+				}
+				for _, m := range tt.missing {
+					if !strings.Contains(err.Error(), m) {
+						t.Errorf("error %q does not mention %q", err, m)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mapHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnIndexGet(t *testing.T) {
+	h := columnIndex{"ID": 0, "Note": 2}
+	tests := []struct {
+		row   []string
+		field string
+		want  string
+	}{
+		{[]string{" A1 ", "x", "note"}, "ID", "A1"},
+		{[]string{"A1", "x", "note"}, "Note", "note"},
+		{[]string{"A1"}, "Note", ""},              // 行比表头短
+		{[]string{"A1", "x", "note"}, "Time", ""}, // 文件中没有该列
+	}
+	for _, tt := range tests {
+		if got := h.get(tt.row, tt.field); got != tt.want {
+			t.Errorf("get(%q, %s) = %q, want %q", tt.row, tt.field, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package importer
+
+import (
+	"app/model"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+func init() {
+	RegisterBilling(model.DefaultBillingPlatform, facebook{})
+}
+
+// facebook FB 广告账单 CSV，每个广告账户一个区块，区块末尾有 Total Amount Billed 总计行
+type facebook struct{}
+
+func (facebook) Ext() string { return "csv" }
+
+func (facebook) Read(filePath string) (*model.BillingFile, error) {
+	return readTransactionRecordsCSV(filePath)
+}
+
+// parseDate FB 账单的日期为 月/日/年
+func parseDate(dateStr string) (model.Date, error) {
+	const layout = "01/02/2006" // Go 的日期格式是固定的，这里是月/日/年
+	parsed, err := time.Parse(layout, dateStr)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse date: %w", err)
+	}
+	return model.Date(parsed.Format(model.DateLayout)), nil
+}
+
+// parseBilledTotal 解析总计行的金额，总计可能带货币符号或币种代码，如 "$1,234.56"、"USD 1,234.56"
+func parseBilledTotal(s string) (model.Money, error) {
+	s = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Sc, r) || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return model.ParseAmount(strings.TrimFunc(s, unicode.IsLetter))
+}
+
+// isBillingTotal 是否为区块末尾的总计行
+func isBillingTotal(record []string) bool {
+	return len(record) > 2 && strings.EqualFold(strings.TrimSpace(record[2]), "Total Amount Billed")
+}
+
+// isBillingHeader 是否为列标题行
+func isBillingHeader(record []string) bool {
+	return len(record) == 5 && record[0] == "Date" && record[1] == "Transaction ID"
+}
+
+// readTransactionRecordsCSV 读取 FB 账单 CSV 并解析出所有账单行，不访问数据库。
+// 一个文件可以包含多个广告账户区块，每个区块以 "Account: " 行开头、以总计行结束，
+// 数据行归属于所在区块的账户。字段不足、日期或金额无法解析的行放入 Rejects，不会中断读取
+func readTransactionRecordsCSV(filePath string) (*model.BillingFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // 允许字段数量不一致
+
+	result := &model.BillingFile{}
+	var section *model.BillingSection
+	accountNumber := ""
+	inRows := false
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rowNum, _ := reader.FieldPos(0)
+
+		// 新的账户区块
+		line := strings.TrimSpace(record[0])
+		if strings.HasPrefix(line, "Account: ") {
+			accountNumber = extractAccountNumber(line)
+			inRows = false
+			continue
+		}
+
+		if !inRows {
+			// 跳过区块开头不必要的行，直到列标题行
+			if len(record) > 5 {
+				return nil, fmt.Errorf("只能识别Date、Transaction ID、Payment Method、Amount、Currency 五列 %s", record)
+			}
+			if isBillingHeader(record) {
+				if result.Header == nil {
+					result.Header = record
+				}
+				section = &model.BillingSection{Account: accountNumber}
+				result.Sections = append(result.Sections, section)
+				inRows = true
+			}
+			continue
+		}
+
+		// 检查是否为总计行，记录总计金额用于核对
+		if isBillingTotal(record) {
+			if len(record) > 3 {
+				if total, err := parseBilledTotal(record[3]); err == nil {
+					section.Total, section.HasTotal = total, true
+				} else {
+					result.Rejects = append(result.Rejects, model.RejectedRow{Row: rowNum, Raw: record, Reason: fmt.Sprintf("总计金额无法解析: %q", record[3]), Warning: true})
+				}
+			}
+			inRows = false
+			accountNumber = ""
+			continue
+		}
+
+		if len(record) < 5 {
+			result.Rejects = append(result.Rejects, model.RejectedRow{Row: rowNum, Raw: record, Reason: "字段不足5列"})
+			continue // 跳过字段不足的记录
+		}
+
+		// 解析行数据到 TransactionRecord 结构
+		var trans model.TransactionRecord
+		trans.Date, err = parseDate(record[0]) // 假设parseDate能够正确处理日期格式
+		if err != nil {
+			result.Rejects = append(result.Rejects, model.RejectedRow{Row: rowNum, Raw: record, Reason: fmt.Sprintf("日期无法解析: %q", record[0])})
+			continue
+		}
+		x, err := model.ParseAmount(record[3])
+		if err != nil {
+			result.Rejects = append(result.Rejects, model.RejectedRow{Row: rowNum, Raw: record, Reason: fmt.Sprintf("金额无法解析: %q", record[3])})
+			continue
+		}
+		if len(record[2]) < 4 {
+			result.Rejects = append(result.Rejects, model.RejectedRow{Row: rowNum, Raw: record, Reason: fmt.Sprintf("Payment Method 不足4位: %q", record[2]), Warning: true})
+		}
+		trans.Account = section.Account
+		trans.TransactionID = record[1]
+		trans.PaymentMethod = model.LastFour(record[2])
+		trans.Amount = x
+		trans.Currency = record[4]
+		section.Rows++
+		result.Rows = append(result.Rows, model.ParsedRecord{Row: rowNum, Raw: record, Section: len(result.Sections) - 1, TransactionRecord: trans})
+	}
+	return result, nil
+}
+
+// extractAccountNumber 从给定的字符串中提取账户数字部分
+// 假设格式为 "Account: 123456789"，这里仅作为示例
+func extractAccountNumber(s string) string {
+	parts := strings.Split(s, " ")
+	if len(parts) > 1 && strings.HasPrefix(parts[0], "Account:") {
+		return parts[1]
+	}
+	return "" // 如果格式不正确，返回空字符串
+}
@@ -0,0 +1,110 @@
+package importer
+
+import (
+	"app/model"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCSV 把 content 写入临时目录中的 CSV 文件
+func writeCSV(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "billing.csv")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadTransactionRecordsCSVSections(t *testing.T) {
+	path := writeCSV(t, `Account: 111 (Ads A)
+Billing report
+Date,Transaction ID,Payment Method,Amount,Currency
+04/01/2024,T1,Visa *1234,"1,200.50",USD
+04/02/2024,T2,Visa *5678,3.00,USD
+,,Total Amount Billed,"1,203.50",
+Account: 222 (Ads B)
+Date,Transaction ID,Payment Method,Amount,Currency
+bad-date,T3,Visa *1234,1.00,USD
+04/03/2024,T4,12,abc,USD
+04/04/2024,T5,12,7.25,USD
+short,row
+,,Total Amount Billed,7.25,
+`)
+	billing, err := readTransactionRecordsCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantRows := []struct {
+		id, account, card string
+		date              model.Date
+		amount            model.Money
+		section           int
+	}{
+		{"T1", "111", "1234", "2024-04-01", 120050, 0},
+		{"T2", "111", "5678", "2024-04-02", 300, 0},
+		{"T5", "222", "12", "2024-04-04", 725, 1},
+	}
+	if len(billing.Rows) != len(wantRows) {
+		t.Fatalf("got %d rows, want %d", len(billing.Rows), len(wantRows))
+	}
+	for i, w := range wantRows {
+		r := billing.Rows[i]
+		if r.TransactionID != w.id || r.Account != w.account || r.PaymentMethod != w.card ||
+			r.Date != w.date || r.Amount != w.amount || r.Section != w.section {
+			t.Errorf("row %d = %+v, want %+v", i, r, w)
+		}
+	}
+
+	if len(billing.Sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(billing.Sections))
+	}
+	wantSections := []model.BillingSection{
+		{Account: "111", Rows: 2, Total: 120350, HasTotal: true},
+		{Account: "222", Rows: 1, Total: 725, HasTotal: true},
+	}
+	for i, w := range wantSections {
+		if *billing.Sections[i] != w {
+			t.Errorf("section %d = %+v, want %+v", i, *billing.Sections[i], w)
+		}
+	}
+
+	// 日期、金额无法解析和字段不足的行被拒绝，卡号不足4位的行导入并给出警告
+	var rejected, warnings int
+	for _, r := range billing.Rejects {
+		if r.Warning {
+			warnings++
+		} else {
+			rejected++
+		}
+	}
+	if rejected != 3 || warnings != 1 {
+		t.Errorf("got %d rejects and %d warnings, want 3 and 1: %+v", rejected, warnings, billing.Rejects)
+	}
+}
+
+func TestParseBilledTotal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    model.Money
+		wantErr bool
+	}{
+		{"1,234.56", 123456, false},
+		{"$1,234.56", 123456, false},
+		{"US$1,234.56", 123456, false},
+		{"USD 1,234.56", 123456, false},
+		{"1,234.56 USD", 123456, false},
+		{"€0.50", 50, false},
+		{"-$3.00", -300, false},
+		{"", 0, true},
+		{"N/A", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBilledTotal(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBilledTotal(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
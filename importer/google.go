@@ -0,0 +1,28 @@
+package importer
+
+import "strings"
+
+// PlatformGoogle Google Ads 账单
+const PlatformGoogle = "google"
+
+func init() {
+	RegisterBilling(PlatformGoogle, columnarBilling{
+		name: "Google Ads ",
+		// Google Ads 结算 → 交易记录（billing activity）导出的列
+		columns: []column{
+			{Field: "Date", Headers: []string{"Date", "日期"}, Required: true},
+			{Field: "TransactionID", Headers: []string{"Transaction ID", "Payment ID", "Reference number", "交易 ID", "交易ID"}, Required: true},
+			{Field: "Description", Headers: []string{"Description", "说明"}},
+			{Field: "PaymentMethod", Headers: []string{"Payment method", "Payment Method", "付款方式"}, Required: true},
+			{Field: "Amount", Headers: []string{"Amount", "金额"}, Required: true},
+			{Field: "Currency", Headers: []string{"Currency", "币种"}},
+			{Field: "Account", Headers: []string{"Customer ID", "Account ID", "客户 ID"}},
+		},
+		dateLayouts:     []string{"Jan 2, 2006", "January 2, 2006", "2006-01-02", "01/02/2006", "2006/01/02"},
+		accountPrefixes: []string{"Customer ID:", "客户 ID:", "Account:"},
+		// 只有付款方式不为空的行是卡上的扣款，广告花费、税费和调整行没有付款方式
+		charge: func(h columnIndex, row []string) bool {
+			return strings.TrimSpace(h.get(row, "PaymentMethod")) != ""
+		},
+	})
+}
@@ -0,0 +1,118 @@
+// Package importer 各虚拟卡供应商导出文件及各广告平台账单文件的解析器。
+// 每个供应商实现 CardImporter、每个广告平台实现 BillingImporter，并在 init 中注册；
+// 上传时按 provider / platform 参数选择解析器，新增供应商或平台无需修改已有的解析代码
+package importer
+
+import (
+	"app/model"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CardImporter 一个虚拟卡供应商的导出文件解析器
+type CardImporter interface {
+	// Ext 导出文件的扩展名，不含点，如 "xlsx"
+	Ext() string
+	// Open 打开导出文件并校验格式，返回逐行读取器。读取器需要把供应商的交易类型
+	// 转换为 model 中统一的交易类型，卡号转换为后四位
+	Open(filePath string) (model.TransactionReader, error)
+}
+
+// BillingImporter 一个广告平台的账单文件解析器
+type BillingImporter interface {
+	// Ext 账单文件的扩展名，不含点，如 "csv"
+	Ext() string
+	// Read 解析整个账单文件，不访问数据库。无法解析的行放入 Rejects，不中断读取
+	Read(filePath string) (*model.BillingFile, error)
+}
+
+var (
+	mu        sync.RWMutex
+	importers = map[string]CardImporter{}
+	billings  = map[string]BillingImporter{}
+)
+
+// Register 以供应商名称注册解析器，名称重复时 panic
+func Register(provider string, imp CardImporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := importers[provider]; ok {
+		panic("importer: 重复注册的供应商 " + provider)
+	}
+	importers[provider] = imp
+}
+
+// Lookup 按供应商名称查找解析器
+func Lookup(provider string) (CardImporter, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	imp, ok := importers[provider]
+	if !ok {
+		return nil, fmt.Errorf("未知的虚拟卡供应商: %s", provider)
+	}
+	return imp, nil
+}
+
+// Providers 已注册的供应商名称，按字母排序
+func Providers() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(importers))
+	for name := range importers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open 用指定供应商的解析器打开文件，供后台导入任务使用
+func Open(provider string, filePath string) (model.TransactionReader, error) {
+	imp, err := Lookup(provider)
+	if err != nil {
+		return nil, err
+	}
+	return imp.Open(filePath)
+}
+
+// RegisterBilling 以广告平台名称注册账单解析器，名称重复时 panic
+func RegisterBilling(platform string, imp BillingImporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := billings[platform]; ok {
+		panic("importer: 重复注册的广告平台 " + platform)
+	}
+	billings[platform] = imp
+}
+
+// LookupBilling 按广告平台名称查找账单解析器
+func LookupBilling(platform string) (BillingImporter, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	imp, ok := billings[platform]
+	if !ok {
+		return nil, fmt.Errorf("未知的广告平台: %s", platform)
+	}
+	return imp, nil
+}
+
+// Platforms 已注册的广告平台名称，按字母排序
+func Platforms() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(billings))
+	for name := range billings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadBilling 用指定广告平台的解析器读取账单文件，供后台导入任务使用
+func ReadBilling(platform string, filePath string) (*model.BillingFile, error) {
+	imp, err := LookupBilling(platform)
+	if err != nil {
+		return nil, err
+	}
+	return imp.Read(filePath)
+}
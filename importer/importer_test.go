@@ -0,0 +1,30 @@
+package importer
+
+import (
+	"app/model"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	if _, err := Lookup(model.DefaultCardProvider); err != nil {
+		t.Errorf("Lookup(%q) = %v, want registered", model.DefaultCardProvider, err)
+	}
+	if _, err := Lookup("no-such-provider"); err == nil {
+		t.Error("Lookup of an unknown provider should fail")
+	}
+	for _, platform := range []string{model.DefaultBillingPlatform, PlatformGoogle, PlatformTikTok} {
+		if _, err := LookupBilling(platform); err != nil {
+			t.Errorf("LookupBilling(%q) = %v, want registered", platform, err)
+		}
+	}
+	if _, err := LookupBilling("no-such-platform"); err == nil {
+		t.Error("LookupBilling of an unknown platform should fail")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a provider twice should panic")
+		}
+	}()
+	Register(model.DefaultCardProvider, vcc{})
+}
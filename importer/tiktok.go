@@ -0,0 +1,33 @@
+package importer
+
+import "strings"
+
+// PlatformTikTok TikTok Ads 账单
+const PlatformTikTok = "tiktok"
+
+func init() {
+	RegisterBilling(PlatformTikTok, columnarBilling{
+		name: "TikTok Ads ",
+		// TikTok Ads Manager 付款 → 交易记录导出的列
+		columns: []column{
+			{Field: "Date", Headers: []string{"Transaction Time", "Time", "Date", "交易时间"}, Required: true},
+			{Field: "TransactionID", Headers: []string{"Transaction ID", "Transaction Number", "交易ID", "交易编号"}, Required: true},
+			{Field: "Account", Headers: []string{"Advertiser ID", "Account ID", "广告账户ID", "广告主ID"}},
+			{Field: "PaymentMethod", Headers: []string{"Payment Method", "Payment method", "支付方式"}, Required: true},
+			{Field: "Amount", Headers: []string{"Amount", "Transaction Amount", "金额"}, Required: true},
+			{Field: "Currency", Headers: []string{"Currency", "币种"}},
+			{Field: "Status", Headers: []string{"Status", "Transaction Status", "状态"}},
+		},
+		dateLayouts:     []string{"2006-01-02 15:04:05", "2006-01-02", "01/02/2006 15:04:05", "01/02/2006", "2006/01/02 15:04:05"},
+		accountPrefixes: []string{"Advertiser ID:", "广告账户ID:"},
+		// 失败或已取消的付款没有从卡上扣款
+		charge: func(h columnIndex, row []string) bool {
+			if strings.TrimSpace(h.get(row, "PaymentMethod")) == "" {
+				return false
+			}
+			status := strings.ToLower(h.get(row, "Status"))
+			return !strings.Contains(status, "fail") && !strings.Contains(status, "cancel") &&
+				!strings.Contains(status, "失败") && !strings.Contains(status, "取消")
+		},
+	})
+}
@@ -0,0 +1,211 @@
+package importer
+
+import (
+	"app/model"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func init() {
+	Register(model.DefaultCardProvider, vcc{})
+}
+
+// vcc 当前使用的虚拟卡供应商，导出为 XLSX，交易类型与 model 中的统一类型一致
+type vcc struct{}
+
+func (vcc) Ext() string { return "xlsx" }
+
+// Open 交易时间按为该供应商配置的时区解析
+func (vcc) Open(filePath string) (model.TransactionReader, error) {
+	return openTransactionsXLSX(filePath, model.SourceLocation(model.DefaultCardProvider))
+}
+
+// transactionColumns VCC 虚拟卡交易导出的列定义，按表头文字而不是列位置匹配，
+// 供应商增删或调整列顺序时无需修改代码；新的表头写法直接加到 Headers 中即可
+var transactionColumns = []column{
+	{Field: "TransactionID", Headers: []string{"交易ID", "交易编号", "交易流水号"}, Required: true},
+	{Field: "TransactionTime", Headers: []string{"交易时间"}, Required: true},
+	{Field: "CardNumber", Headers: []string{"卡号"}, Required: true},
+	{Field: "Nickname", Headers: []string{"昵称", "卡昵称"}, Required: true},
+	{Field: "BillName", Headers: []string{"账单名称", "商户名称"}},
+	{Field: "TransactionType", Headers: []string{"交易类型"}, Required: true},
+	{Field: "OrderAmount", Headers: []string{"订单金额"}, Required: true},
+	{Field: "OrderCurrency", Headers: []string{"订单币种"}},
+	{Field: "TransactionAmount", Headers: []string{"交易金额"}},
+	{Field: "TransactionFee", Headers: []string{"交易费", "交易手续费", "手续费"}},
+	{Field: "TransactionCurrency", Headers: []string{"交易币种"}},
+	{Field: "TransactionStatus", Headers: []string{"交易状态"}},
+	{Field: "AuthorizationCode", Headers: []string{"授权码"}},
+	{Field: "ResultCode", Headers: []string{"结果码"}},
+	{Field: "ResultDescription", Headers: []string{"结果描述"}},
+	{Field: "SettlementStatus", Headers: []string{"清算状态"}},
+}
+
+// mapXLSXHeader 根据标题行定位 VCC 导出各字段所在的列
+func mapXLSXHeader(header []string) (columnIndex, error) {
+	h, err := mapHeader(header, transactionColumns)
+	if err != nil {
+		return nil, fmt.Errorf("虚拟卡文件%v", err)
+	}
+	return h, nil
+}
+
+// transaction 把一行数据解析为 Transaction，交易时间是 loc 时区的时间，为空或无法解析的可选字段记在 Blank 中。
+// 交易ID为空、交易时间或订单金额无法解析时返回错误；其余可以导入但值得人工核对的问题放在 warnings 中
+func (h columnIndex) transaction(row []string, loc *time.Location) (p model.ParsedTransaction, warnings []string, err error) {
+	trans := &p.Transaction
+	markBlank := func(field string) {
+		if p.Blank == nil {
+			p.Blank = map[string]bool{}
+		}
+		p.Blank[field] = true
+	}
+	for _, col := range transactionColumns {
+		if !col.Required && h.get(row, col.Field) == "" {
+			markBlank(col.Field)
+		}
+	}
+	trans.TransactionID = h.get(row, "TransactionID")
+	if trans.TransactionID == "" {
+		return p, nil, errors.New("交易ID为空")
+	}
+	if trans.TransactionTime, err = time.ParseInLocation(model.TransactionTimeLayout, h.get(row, "TransactionTime"), loc); err != nil {
+		return p, nil, fmt.Errorf("交易时间格式无法识别: %q", h.get(row, "TransactionTime"))
+	}
+	cardNumber := h.get(row, "CardNumber")
+	if len(cardNumber) < 4 {
+		warnings = append(warnings, fmt.Sprintf("卡号不足4位: %q", cardNumber))
+	}
+	trans.CardNumber = model.LastFour(cardNumber)
+	trans.Nickname = h.get(row, "Nickname")
+	trans.BillName = h.get(row, "BillName")
+	trans.TransactionType = h.get(row, "TransactionType")
+	if !model.IsTransactionType(trans.TransactionType) {
+		warnings = append(warnings, fmt.Sprintf("未知的交易类型: %q", trans.TransactionType))
+	}
+	if trans.OrderAmount, err = model.ParseAmount(h.get(row, "OrderAmount")); err != nil {
+		return p, nil, fmt.Errorf("订单金额无法解析: %q", h.get(row, "OrderAmount"))
+	}
+	trans.OrderCurrency = h.get(row, "OrderCurrency")
+	if v := h.get(row, "TransactionAmount"); v != "" {
+		if trans.TransactionAmount, err = model.ParseAmount(v); err != nil {
+			warnings = append(warnings, fmt.Sprintf("交易金额无法解析，按0导入: %q", v))
+			markBlank("TransactionAmount")
+		}
+	}
+	if v := h.get(row, "TransactionFee"); v != "" {
+		if trans.TransactionFee, err = model.ParseAmount(v); err != nil {
+			warnings = append(warnings, fmt.Sprintf("交易费无法解析，按0导入: %q", v))
+			markBlank("TransactionFee")
+		}
+	}
+	trans.TransactionCurrency = h.get(row, "TransactionCurrency")
+	trans.TransactionStatus = h.get(row, "TransactionStatus")
+	trans.AuthorizationCode = h.get(row, "AuthorizationCode")
+	trans.ResultCode = h.get(row, "ResultCode")
+	trans.ResultDescription = h.get(row, "ResultDescription")
+	trans.SettlementStatus = h.get(row, "SettlementStatus")
+	return p, warnings, nil
+}
+
+// transactionXLSXReader 逐行流式读取虚拟卡文件，不会把整张表读入内存
+type transactionXLSXReader struct {
+	f      *excelize.File
+	rows   *excelize.Rows
+	header []string // 文件的标题行
+	cols   columnIndex
+	loc    *time.Location // 交易时间所用的时区
+	rowNum int
+	// estimate 按工作表尺寸估计的数据行数，为0表示未知
+	estimate int
+}
+
+// openTransactionsXLSX 打开虚拟卡文件并校验标题行，数据在第一张表，第一行为标题行
+func openTransactionsXLSX(filePath string, loc *time.Location) (*transactionXLSXReader, error) {
+	f, err := excelize.OpenFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	r := &transactionXLSXReader{f: f, loc: loc}
+	if last := firstSheetRows(filePath); last > 1 {
+		r.estimate = last - 1
+	}
+
+	r.rows, err = f.Rows(f.GetSheetName(0))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !r.rows.Next() {
+		r.Close()
+		return nil, errors.New("no data found in the Excel file")
+	}
+	r.rowNum = 1
+	if r.header, err = r.rows.Columns(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if r.cols, err = mapXLSXHeader(r.header); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Header 文件的标题行
+func (r *transactionXLSXReader) Header() []string {
+	return r.header
+}
+
+// EstimatedRows 按工作表尺寸估计的数据行数（不含标题行），可能包含空行，为0表示未知
+func (r *transactionXLSXReader) EstimatedRows() int {
+	return r.estimate
+}
+
+// Each 依次解析每个数据行，文件中没有任何数据行时返回错误
+func (r *transactionXLSXReader) Each(onRow func(model.ParsedTransaction) error, onReject func(model.RejectedRow)) error {
+	data := 0
+	for r.rows.Next() {
+		r.rowNum++
+		row, err := r.rows.Columns()
+		if err != nil {
+			return err
+		}
+		if len(strings.Join(row, "")) == 0 {
+			continue // 空行
+		}
+		data++
+		// 解析行数据到 Transaction 结构
+		p, warnings, err := r.cols.transaction(row, r.loc)
+		if err != nil {
+			onReject(model.RejectedRow{Row: r.rowNum, Raw: row, Reason: err.Error()})
+			continue
+		}
+		for _, w := range warnings {
+			onReject(model.RejectedRow{Row: r.rowNum, Raw: row, Reason: w, Warning: true})
+		}
+		p.Row, p.Raw = r.rowNum, row
+		if err := onRow(p); err != nil {
+			return err
+		}
+	}
+	if err := r.rows.Error(); err != nil {
+		return err
+	}
+	if data == 0 { // 至少应该有一行数据
+		return errors.New("no data found in the Excel file")
+	}
+	return nil
+}
+
+// Close 关闭文件
+func (r *transactionXLSXReader) Close() error {
+	if r.rows != nil {
+		_ = r.rows.Close()
+	}
+	return r.f.Close()
+}
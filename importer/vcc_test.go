@@ -0,0 +1,260 @@
+package importer
+
+import (
+	"app/model"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const benchRows = 100000
+
+// writeBenchXLSX 生成 n 行数据的虚拟卡导出文件
+func writeBenchXLSX(b *testing.B, n int) string {
+	b.Helper()
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sw, err := f.NewStreamWriter("Sheet1")
+	if err != nil {
+		b.Fatal(err)
+	}
+	header := []interface{}{"交易编号", "交易时间", "卡号", "昵称", "账单名称", "交易类型", "订单金额", "订单币种",
+		"交易金额", "交易费", "交易币种", "交易状态", "授权码", "结果码", "结果描述", "清算状态"}
+	if err := sw.SetRow("A1", header); err != nil {
+		b.Fatal(err)
+	}
+	types := []string{"交易授权", "交易清算", "卡充值", "交易授权撤销"}
+	for i := 0; i < n; i++ {
+		amount := fmt.Sprintf("%.2f", -float64(i%5000)/100)
+		row := []interface{}{
+			fmt.Sprintf("BENCH%012d", i),
+			fmt.Sprintf("2024-%02d-%02d %02d:%02d:%02d", i%6+1, i%28+1, i%24, i%60, i%60),
+			fmt.Sprintf("556167******%04d", i%3000),
+			fmt.Sprintf("%d", 189505894+i%20),
+			"FACEBK *ADS Menlo Park CA US",
+			types[i%len(types)],
+			amount, "USD", amount, "0.00", "USD", "成功", "", "APPROVED", "succeed", "无需清算",
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := sw.SetRow(cell, row); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(b.TempDir(), "bench.xlsx")
+	if err := f.SaveAs(path); err != nil {
+		b.Fatal(err)
+	}
+	return path
+}
+
+// BenchmarkReadTransactionsXLSX 流式逐行解析
+func BenchmarkReadTransactionsXLSX(b *testing.B) {
+	path := writeBenchXLSX(b, benchRows)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader, err := openTransactionsXLSX(path, time.UTC)
+		if err != nil {
+			b.Fatal(err)
+		}
+		rows := 0
+		err = reader.Each(func(model.ParsedTransaction) error {
+			rows++
+			return nil
+		}, func(model.RejectedRow) {})
+		reader.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if rows != benchRows {
+			b.Fatalf("parsed %d rows, want %d", rows, benchRows)
+		}
+	}
+}
+
+// readTransactionsGetRows 原先的读取方式：用 GetRows 把整张表读入内存后逐行解析，返回解析成功的行
+func readTransactionsGetRows(path string, loc *time.Location) ([]model.ParsedTransaction, error) {
+	f, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rows, err := f.GetRows(f.GetSheetName(0))
+	if err != nil {
+		return nil, err
+	}
+	header, err := mapXLSXHeader(rows[0])
+	if err != nil {
+		return nil, err
+	}
+	parsed := make([]model.ParsedTransaction, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		if p, _, err := header.transaction(row, loc); err == nil {
+			p.Row, p.Raw = i+2, row
+			parsed = append(parsed, p)
+		}
+	}
+	return parsed, nil
+}
+
+// BenchmarkReadTransactionsXLSXGetRows 原先用 GetRows 一次读入整张表的方式，作为对照
+func BenchmarkReadTransactionsXLSXGetRows(b *testing.B) {
+	path := writeBenchXLSX(b, benchRows)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parsed, err := readTransactionsGetRows(path, time.UTC)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(parsed) != benchRows {
+			b.Fatalf("parsed %d rows, want %d", len(parsed), benchRows)
+		}
+	}
+}
+
+// TestReadTransactionsXLSXMatchesGetRows 流式读取与原先 GetRows 的读取方式解析出相同的行
+func TestReadTransactionsXLSXMatchesGetRows(t *testing.T) {
+	f := excelize.NewFile()
+	defer f.Close()
+	rows := [][]interface{}{
+		{"交易编号", "交易时间", "卡号", "昵称", "账单名称", "交易类型", "订单金额", "订单币种", "交易金额", "交易费", "交易状态", "结果码"},
+		{"T1", "2024-04-01 08:00:00", "556167******1234", "A", "FACEBK ADS", "交易授权", "-10.50", "USD", "-10.50", "0.10", "成功", "APPROVED"},
+		{"T2", "2024-04-01 09:00:00", "556167******1234", "A", "", "卡充值", 200, "USD"}, // 数字单元格，末尾的列为空
+		nil, // 空行
+		{"T3", "2024/04/01", "556167******5678", "B", "", "交易授权", "-1.00"}, // 交易时间无法解析
+		{"T4", "2024-04-02 10:00:00", "556167******5678", "B", "", "未知类型", "-2.00", "USD", "abc"},
+		{"", "2024-04-02 11:00:00", "556167******5678", "B"},                               // 交易ID为空
+		{"T1", "2024-04-03 08:00:00", "556167******1234", "A", "", "交易授权", "-3.00", "USD"}, // 文件内重复，由导入统一拒绝
+		{"T5", "2024-04-03 12:00:00", "1234", "C", "", "交易授权撤销", "1,234.56", "USD", "", "", "", "", "多余的列"},
+	}
+	for i, row := range rows {
+		if row == nil {
+			continue
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := f.SetSheetRow("Sheet1", cell, &row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "cards.xlsx")
+	if err := f.SaveAs(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loc := time.FixedZone("UTC+8", 8*3600)
+	want, err := readTransactionsGetRows(path, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := openTransactionsXLSX(path, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var got []model.ParsedTransaction
+	if err := reader.Each(func(p model.ParsedTransaction) error {
+		got = append(got, p)
+		return nil
+	}, func(model.RejectedRow) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(want) != 5 {
+		t.Fatalf("GetRows parsed %d rows, want 5", len(want))
+	}
+	if !reflect.DeepEqual(got, want) {
+		for i := 0; i < len(got) || i < len(want); i++ {
+			var g, w model.ParsedTransaction
+			if i < len(got) {
+				g = got[i]
+			}
+			if i < len(want) {
+				w = want[i]
+			}
+			if !reflect.DeepEqual(g, w) {
+				t.Errorf("row %d:\n stream  %+v\n GetRows %+v", i, g, w)
+			}
+		}
+	}
+}
+
+func TestColumnIndexTransaction(t *testing.T) {
+	header := []string{"交易编号", "交易时间", "卡号", "昵称", "交易类型", "订单金额", "交易金额", "交易费", "交易状态"}
+	h, err := mapXLSXHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		name         string
+		row          []string
+		wantErr      bool
+		wantWarnings int
+		wantBlank    []string
+		wantFee      model.Money
+	}{
+		{
+			name:      "完整的行，文件中没有的列记为未提供",
+			row:       []string{"T1", "2024-04-01 08:00:00", "556167******1234", "189", "交易授权", "-12.50", "-12.50", "0.00", "成功"},
+			wantBlank: []string{"BillName", "OrderCurrency", "TransactionCurrency", "AuthorizationCode", "ResultCode", "ResultDescription", "SettlementStatus"},
+		},
+		{
+			name:      "空单元格记为未提供，0 照常导入",
+			row:       []string{"T1", "2024-04-01 08:00:00", "556167******1234", "189", "交易授权", "-12.50", "", "0", ""},
+			wantBlank: []string{"TransactionAmount", "TransactionStatus"},
+		},
+		{
+			name:         "无法解析的交易费给出警告并记为未提供",
+			row:          []string{"T1", "2024-04-01 08:00:00", "12", "189", "交易授权", "-12.50", "-12.50", "n/a", "成功"},
+			wantWarnings: 2, // 卡号不足4位、交易费无法解析
+			wantBlank:    []string{"TransactionFee"},
+		},
+		{
+			name:    "交易ID为空",
+			row:     []string{"", "2024-04-01 08:00:00", "1234", "189", "交易授权", "-12.50"},
+			wantErr: true,
+		},
+		{
+			name:    "交易时间无法解析",
+			row:     []string{"T1", "04/01/2024", "1234", "189", "交易授权", "-12.50"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, warnings, err := h.transaction(tt.row, loc)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("transaction() = %+v, want error", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.wantWarnings)
+			}
+			for _, f := range tt.wantBlank {
+				if !p.Blank[f] {
+					t.Errorf("%s should be blank, Blank = %v", f, p.Blank)
+				}
+			}
+			if !p.Blank["TransactionFee"] && p.TransactionFee != tt.wantFee {
+				t.Errorf("TransactionFee = %v, want %v", p.TransactionFee, tt.wantFee)
+			}
+			if !p.TransactionTime.Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)) {
+				t.Errorf("TransactionTime = %v, want 2024-04-01 00:00 UTC", p.TransactionTime)
+			}
+		})
+	}
+}
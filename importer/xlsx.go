@@ -0,0 +1,110 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// firstSheetRows 按第一张表记录的尺寸（dimension）估计其最后一行的行号，文件没有记录尺寸或无法读取时为0。
+// 只读取工作表 XML 开头的 dimension 元素，不解析数据，尺寸中可能包含空行或格式行
+func firstSheetRows(filePath string) int {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return 0
+	}
+	defer zr.Close()
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	// 第一张表的关系ID -> 工作表 XML 路径
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if !decodeZipXML(files["xl/workbook.xml"], &workbook) || len(workbook.Sheets) == 0 {
+		return 0
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if !decodeZipXML(files["xl/_rels/workbook.xml.rels"], &rels) {
+		return 0
+	}
+	var sheetPath string
+	for _, r := range rels.Relationships {
+		if r.ID == workbook.Sheets[0].ID {
+			if strings.HasPrefix(r.Target, "/") {
+				sheetPath = strings.TrimPrefix(r.Target, "/")
+			} else {
+				sheetPath = path.Join("xl", r.Target)
+			}
+			break
+		}
+	}
+	sheet, ok := files[sheetPath]
+	if !ok {
+		return 0
+	}
+	rc, err := sheet.Open()
+	if err != nil {
+		return 0
+	}
+	defer rc.Close()
+
+	d := xml.NewDecoder(rc)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return 0
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "dimension":
+			for _, a := range start.Attr {
+				if a.Name.Local == "ref" {
+					return refLastRow(a.Value)
+				}
+			}
+			return 0
+		case "sheetData":
+			return 0 // dimension 在 sheetData 之前，已经错过
+		}
+	}
+}
+
+// refLastRow 区域引用（如 A1:P1001）的最后一行
+func refLastRow(ref string) int {
+	last := ref[strings.LastIndex(ref, ":")+1:]
+	_, row, err := excelize.SplitCellName(last)
+	if err != nil {
+		return 0
+	}
+	return row
+}
+
+// decodeZipXML 解析压缩包中的一个 XML 文件
+func decodeZipXML(f *zip.File, v interface{}) bool {
+	if f == nil {
+		return false
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	return xml.NewDecoder(io.LimitReader(rc, 16<<20)).Decode(v) == nil
+}
@@ -0,0 +1,123 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestEstimatedRows(t *testing.T) {
+	header := []interface{}{"交易编号", "交易时间", "卡号", "昵称", "交易类型", "订单金额"}
+	row := func(i int) []interface{} {
+		return []interface{}{i, "2024-04-01 08:00:00", "556167******1234", "A", "交易授权", "-1.00"}
+	}
+	write := func(t *testing.T, name string, fill func(f *excelize.File) error) string {
+		f := excelize.NewFile()
+		defer f.Close()
+		if err := fill(f); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), name)
+		if err := f.SaveAs(path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// fill 写入标题行和 n 行数据，dimension 不为空时按 Excel 的方式记录工作表尺寸（excelize 默认只写 A1）
+	fill := func(n int, dimension string) func(f *excelize.File) error {
+		return func(f *excelize.File) error {
+			if err := f.SetSheetRow("Sheet1", "A1", &header); err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				cell, _ := excelize.CoordinatesToCellName(1, i+2)
+				r := row(i)
+				if err := f.SetSheetRow("Sheet1", cell, &r); err != nil {
+					return err
+				}
+			}
+			if dimension == "" {
+				return nil
+			}
+			return f.SetSheetDimension("Sheet1", dimension)
+		}
+	}
+
+	tests := []struct {
+		name string
+		path func(t *testing.T) string
+		want int
+	}{
+		{
+			name: "按记录的尺寸估计，不含标题行",
+			path: func(t *testing.T) string { return write(t, "excel.xlsx", fill(3, "A1:F4")) },
+			want: 3,
+		},
+		{
+			name: "尺寸只有一个单元格时未知",
+			path: func(t *testing.T) string { return write(t, "a1.xlsx", fill(3, "")) },
+			want: 0,
+		},
+		{
+			name: "只看第一张表",
+			path: func(t *testing.T) string {
+				return write(t, "sheets.xlsx", func(f *excelize.File) error {
+					if err := fill(1, "A1:F2")(f); err != nil {
+						return err
+					}
+					if _, err := f.NewSheet("Other"); err != nil {
+						return err
+					}
+					if err := f.SetCellValue("Other", "A100", "x"); err != nil {
+						return err
+					}
+					return f.SetSheetDimension("Other", "A1:A100")
+				})
+			},
+			want: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := openTransactionsXLSX(tt.path(t), time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reader.Close()
+			if got := reader.EstimatedRows(); got != tt.want {
+				t.Errorf("EstimatedRows() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// 不是 XLSX 的文件无法估计
+	path := filepath.Join(t.TempDir(), "not.xlsx")
+	if err := os.WriteFile(path, []byte("交易编号\n1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := firstSheetRows(path); got != 0 {
+		t.Errorf("firstSheetRows(non-xlsx) = %d, want 0", got)
+	}
+}
+
+func TestRefLastRow(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"A1:P1001", 1001},
+		{"A1", 1},
+		{"$A$1:$B$20", 20},
+		{"", 0},
+		{"A1:", 0},
+	}
+	for _, tt := range tests {
+		if got := refLastRow(tt.ref); got != tt.want {
+			t.Errorf("refLastRow(%q) = %d, want %d", tt.ref, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"app/importer"
 	"app/route"
 	"app/model"
 )
@@ -8,6 +9,10 @@ import (
 func main() {
 	// 引用数据库
 	model.InitDb()
+	// 启动后台导入任务
+	model.StartImportWorker(importer.Open, importer.ReadBilling)
+	// 定时重新核对未核对的账单
+	model.StartReconcileScheduler()
 	// 引入路由组件
 	route.InitRouter()
 
@@ -40,24 +40,42 @@ func (j *JWT) CreateToken(claims MyClaims) (string, error) {
 }
 
 // ParserToken 解析token
-func (j *JWT) ParserToken(tokenString string) error {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+func (j *JWT) ParserToken(tokenString string) (*MyClaims, error) {
+	claims := &MyClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return j.JwtKey, nil
 	})
 	// 验证token
-	if token.Valid {
-		return nil
+	if token != nil && token.Valid {
+		return claims, nil
 	} else if errors.Is(err, jwt.ErrTokenMalformed) {
-		return TokenMalformed
+		return nil, TokenMalformed
 	} else if errors.Is(err, jwt.ErrTokenExpired) || errors.Is(err, jwt.ErrTokenNotValidYet) {
-		return TokenExpired
+		return nil, TokenExpired
 	} else if errors.Is(err, jwt.ErrTokenSignatureInvalid) {
-		return TokenInvalid
+		return nil, TokenInvalid
 	} else {
-		return TokenNotValidYet
+		return nil, TokenNotValidYet
 	}
 }
 
+// CurrentUser 返回当前请求的用户名：经过 JwtToken 的路由直接取上下文中的用户名，
+// 其他路由在携带了有效 token 时解析得到，否则返回空字符串
+func CurrentUser(c *gin.Context) string {
+	if username := c.GetString("username"); username != "" {
+		return username
+	}
+	checkToken := strings.Split(c.Request.Header.Get("Authorization"), " ")
+	if len(checkToken) != 2 || checkToken[0] != "Bearer" {
+		return ""
+	}
+	claims, err := NewJWT().ParserToken(checkToken[1])
+	if err != nil {
+		return ""
+	}
+	return claims.Username
+}
+
 // JwtToken jwt中间件
 // todo 优化此类代码
 func JwtToken() gin.HandlerFunc {
@@ -95,7 +113,7 @@ func JwtToken() gin.HandlerFunc {
 
 		j := NewJWT()
 		// 解析token
-		err := j.ParserToken(checkToken[1])
+		claims, err := j.ParserToken(checkToken[1])
 		if err != nil {
 			if errors.Is(err, TokenExpired) {
 				c.JSON(http.StatusOK, gin.H{
@@ -116,7 +134,7 @@ func JwtToken() gin.HandlerFunc {
 			return
 		}
 
-		//c.Set("username",)
+		c.Set("username", claims.Username)
 		c.Next()
 	}
 }
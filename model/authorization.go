@@ -0,0 +1,469 @@
+package model
+
+import (
+	"app/utils"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 交易授权的状态
+const (
+	AuthOpen           = "open"            // 尚未清算、退款或撤销
+	AuthSettled        = "settled"         // 已清算
+	AuthPartlyRefunded = "partly_refunded" // 部分金额已退款或撤销
+	AuthRefunded       = "refunded"        // 全部金额已退款
+	AuthReversed       = "reversed"        // 全部金额已撤销
+)
+
+// AuthorizationLink 交易退款、交易授权撤销、交易清算与原交易授权的关联。
+// 由 linkAuthorizations 按卡号、昵称、金额、商户（BillName）和时间推断，每笔关联交易最多关联一笔授权；
+// 导入或回滚虚拟卡交易后对涉及的卡整体重新推断
+type AuthorizationLink struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	AuthorizationID string    `gorm:"type:varchar(50);index" json:"authorization_id"` // 交易授权的交易ID
+	LinkedID        string    `gorm:"type:varchar(50);uniqueIndex" json:"linked_id"`  // 退款、撤销或清算的交易ID
+	LinkType        string    `gorm:"type:varchar(100)" json:"link_type"`             // 关联交易的交易类型
+	CardNumber      string    `gorm:"type:varchar(200);index" json:"card_number"`
+	Nickname        string    `gorm:"type:varchar(100)" json:"nickname"`
+	Amount          Money     `gorm:"type:decimal(10,2)" json:"amount"` // 关联交易订单金额的绝对值
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// cardKey 一张卡：同一卡号在不同昵称下分别计算
+type cardKey struct{ Nickname, CardNumber string }
+
+// linkedTypes 需要关联到交易授权的交易类型
+var linkedTypes = []string{TypeSettlement, TypeRefund, TypeAuthReversal}
+
+// merchantMatch 两边的商户都不为空时必须相同；exact 表示两边都不为空且相同
+func merchantMatch(a string, b string) (ok bool, exact bool) {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	if a == "" || b == "" {
+		return true, false
+	}
+	return a == b, a == b
+}
+
+// linkAuthorizations 把一张卡的交易清算、交易退款、交易授权撤销关联到原交易授权，trans 为该卡的全部交易。
+// 金额为0的授权是验卡交易，不参与关联。
+//   - 交易清算：与授权相差不超过 settleWindow，金额相同或商户相同；每笔授权最多一笔清算
+//   - 退款、撤销：在授权之后 refundWindow 内，金额不超过授权尚未退回的金额，可以分多次部分退回
+//
+// 候选授权依次按商户相同、金额恰好相等、时间最近挑选
+func linkAuthorizations(trans []Transaction, settleWindow time.Duration, refundWindow time.Duration) []AuthorizationLink {
+	type auth struct {
+		Transaction
+		remaining Money
+		settled   bool
+	}
+	var auths []*auth
+	var linked []Transaction
+	for _, t := range trans {
+		switch t.TransactionType {
+		case TypeAuthorization:
+			if t.OrderAmount != 0 {
+				auths = append(auths, &auth{Transaction: t, remaining: t.OrderAmount.Abs()})
+			}
+		case TypeSettlement, TypeRefund, TypeAuthReversal:
+			if t.OrderAmount != 0 {
+				linked = append(linked, t)
+			}
+		}
+	}
+	sort.SliceStable(linked, func(i, j int) bool {
+		// 先关联清算，退款、撤销按时间顺序依次冲抵
+		si, sj := linked[i].TransactionType == TypeSettlement, linked[j].TransactionType == TypeSettlement
+		if si != sj {
+			return si
+		}
+		if !linked[i].TransactionTime.Equal(linked[j].TransactionTime) {
+			return linked[i].TransactionTime.Before(linked[j].TransactionTime)
+		}
+		return linked[i].TransactionID < linked[j].TransactionID
+	})
+
+	var links []AuthorizationLink
+	for _, t := range linked {
+		amount := t.OrderAmount.Abs()
+		var best *auth
+		var bestExact, bestEqual bool
+		var bestGap time.Duration
+		for _, a := range auths {
+			if !strings.EqualFold(a.OrderCurrency, t.OrderCurrency) {
+				continue
+			}
+			ok, exact := merchantMatch(a.BillName, t.BillName)
+			if !ok {
+				continue
+			}
+			gap := t.TransactionTime.Sub(a.TransactionTime)
+			var equal bool
+			if t.TransactionType == TypeSettlement {
+				if gap < 0 {
+					gap = -gap
+				}
+				equal = amount == a.OrderAmount.Abs()
+				if a.settled || gap > settleWindow || !(equal || exact) {
+					continue
+				}
+			} else {
+				// 供应商导出的时间精确到秒，同一秒内的先后不可靠
+				if gap < -time.Minute || gap > refundWindow || amount > a.remaining {
+					continue
+				}
+				if gap < 0 {
+					gap = -gap
+				}
+				equal = amount == a.remaining
+			}
+			better := best == nil
+			switch {
+			case better:
+			case exact != bestExact:
+				better = exact
+			case equal != bestEqual:
+				better = equal
+			case gap != bestGap:
+				better = gap < bestGap
+			}
+			if better {
+				best, bestExact, bestEqual, bestGap = a, exact, equal, gap
+			}
+		}
+		if best == nil {
+			continue
+		}
+		if t.TransactionType == TypeSettlement {
+			best.settled = true
+		} else {
+			best.remaining -= amount
+		}
+		links = append(links, AuthorizationLink{
+			AuthorizationID: best.TransactionID,
+			LinkedID:        t.TransactionID,
+			LinkType:        t.TransactionType,
+			CardNumber:      t.CardNumber,
+			Nickname:        t.Nickname,
+			Amount:          amount,
+		})
+	}
+	return links
+}
+
+// relinkAuthorizations 删除 keys 中各卡的关联并按全部交易重新推断
+func relinkAuthorizations(tx *gorm.DB, keys []cardKey) error {
+	settleWindow := time.Duration(utils.SettlementWindowDays) * 24 * time.Hour
+	refundWindow := time.Duration(utils.RefundWindowDays) * 24 * time.Hour
+	types := append([]string{TypeAuthorization}, linkedTypes...)
+	for _, k := range keys {
+		if err := tx.Where("nickname = ? AND card_number = ?", k.Nickname, k.CardNumber).
+			Delete(&AuthorizationLink{}).Error; err != nil {
+			return err
+		}
+		var trans []Transaction
+		if err := tx.Select("transaction_id, transaction_time, card_number, nickname, bill_name, transaction_type, order_amount, order_currency").
+			Where("nickname = ? AND card_number = ? AND transaction_type IN ?", k.Nickname, k.CardNumber, types).
+			Order("transaction_time ASC, transaction_id ASC").
+			Find(&trans).Error; err != nil {
+			return err
+		}
+		links := linkAuthorizations(trans, settleWindow, refundWindow)
+		if len(links) == 0 {
+			continue
+		}
+		if err := tx.CreateInBatches(&links, 500).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transactionCards 查询条件 where 选出的交易所在的卡
+func transactionCards(tx *gorm.DB, where string, args ...interface{}) ([]cardKey, error) {
+	var keys []cardKey
+	err := tx.Model(&Transaction{}).Distinct("nickname", "card_number").
+		Where(where, args...).
+		Order("nickname ASC, card_number ASC").
+		Scan(&keys).Error
+	return keys, err
+}
+
+// RelinkAuthorizations 按当前配置对所有卡重新推断退款、撤销、清算与交易授权的关联，返回关联数
+func RelinkAuthorizations() (int64, error) {
+	var count int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		keys, err := transactionCards(tx, "transaction_type = ?", TypeAuthorization)
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("1 = 1").Delete(&AuthorizationLink{}).Error; err != nil {
+			return err
+		}
+		if err := relinkAuthorizations(tx, keys); err != nil {
+			return err
+		}
+		return tx.Model(&AuthorizationLink{}).Count(&count).Error
+	})
+	return count, err
+}
+
+// backfillAuthorizationLinks 关联表为空而已有交易授权时（升级前的数据）推断所有卡的关联
+func backfillAuthorizationLinks() error {
+	var count int64
+	if err := db.Model(&AuthorizationLink{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	var auths int64
+	if err := db.Model(&Transaction{}).Where("transaction_type = ?", TypeAuthorization).Count(&auths).Error; err != nil || auths == 0 {
+		return err
+	}
+	_, err := RelinkAuthorizations()
+	return err
+}
+
+// AuthorizationSummary 一笔交易授权及其退款、撤销、清算情况，金额均为绝对值
+type AuthorizationSummary struct {
+	TransactionID   string     `json:"transaction_id"`
+	TransactionTime time.Time  `json:"transaction_time"`
+	CardNumber      string     `json:"card_number"`
+	Nickname        string     `json:"nickname"`
+	BillName        string     `json:"bill_name"`
+	Currency        string     `json:"currency"`
+	Amount          Money      `json:"amount"`
+	Refunded        Money      `json:"refunded"`
+	Reversed        Money      `json:"reversed"`
+	NetAmount       Money      `json:"net_amount"` // 授权金额减去已退款和已撤销的金额
+	SettlementID    string     `json:"settlement_id"`
+	SettledAt       *time.Time `json:"settled_at"`     // 交易清算的时间
+	LastRefundAt    *time.Time `json:"last_refund_at"` // 最后一笔退款或撤销的时间
+	ClosedAt        *time.Time `json:"closed_at"`      // 不再占用卡余额的时间：清算或全部退回
+	OpenDays        int        `json:"open_days"`      // 从授权到 ClosedAt 的天数，尚未结束时计到当前时间
+	Status          string     `json:"status"`
+}
+
+// fillLifecycle 根据清算和退回的时间计算 ClosedAt、OpenDays
+func (s *AuthorizationSummary) fillLifecycle(now time.Time) {
+	switch {
+	case s.SettledAt != nil:
+		s.ClosedAt = s.SettledAt
+	case s.NetAmount <= 0 && s.LastRefundAt != nil:
+		s.ClosedAt = s.LastRefundAt
+	}
+	end := now
+	if s.ClosedAt != nil {
+		end = *s.ClosedAt
+	}
+	if d := end.Sub(s.TransactionTime); d > 0 {
+		s.OpenDays = int(d / (24 * time.Hour))
+	}
+}
+
+// authorizationQuery 交易授权及其关联合计，状态由 authStatusExpr 计算。不含金额为0的验卡授权
+func authorizationQuery(tx *gorm.DB) *gorm.DB {
+	sums := tx.Table("authorization_link AS al").
+		Joins("JOIN `transaction` AS lt ON lt.transaction_id = al.linked_id").
+		Select("al.authorization_id, "+
+			"SUM(CASE WHEN al.link_type = ? THEN al.amount ELSE 0 END) AS refunded, "+
+			"SUM(CASE WHEN al.link_type = ? THEN al.amount ELSE 0 END) AS reversed, "+
+			"MAX(CASE WHEN al.link_type = ? THEN al.linked_id END) AS settlement_id, "+
+			"MAX(CASE WHEN al.link_type = ? THEN lt.transaction_time END) AS settled_at, "+
+			"MAX(CASE WHEN al.link_type <> ? THEN lt.transaction_time END) AS last_refund_at",
+			TypeRefund, TypeAuthReversal, TypeSettlement, TypeSettlement, TypeSettlement).
+		Group("al.authorization_id")
+	return tx.Table("`transaction` AS t").
+		Joins("LEFT JOIN (?) AS l ON l.authorization_id = t.transaction_id", sums).
+		Where("t.transaction_type = ? AND t.order_amount <> 0", TypeAuthorization)
+}
+
+// authStatusExpr 交易授权状态的 SQL 表达式，与 AuthorizationSummary.Status 一致
+var authStatusExpr = "CASE" +
+	" WHEN COALESCE(l.reversed, 0) >= ABS(t.order_amount) THEN '" + AuthReversed + "'" +
+	" WHEN COALESCE(l.refunded, 0) + COALESCE(l.reversed, 0) >= ABS(t.order_amount) THEN '" + AuthRefunded + "'" +
+	" WHEN COALESCE(l.refunded, 0) + COALESCE(l.reversed, 0) > 0 THEN '" + AuthPartlyRefunded + "'" +
+	" WHEN l.settlement_id IS NOT NULL THEN '" + AuthSettled + "'" +
+	" ELSE '" + AuthOpen + "' END"
+
+// authorizationColumns 查询 AuthorizationSummary 的列
+const authorizationColumns = "t.transaction_id, t.transaction_time, t.card_number, t.nickname, t.bill_name, " +
+	"UPPER(t.order_currency) AS currency, ABS(t.order_amount) AS amount, " +
+	"COALESCE(l.refunded, 0) AS refunded, COALESCE(l.reversed, 0) AS reversed, " +
+	"ABS(t.order_amount) - COALESCE(l.refunded, 0) - COALESCE(l.reversed, 0) AS net_amount, " +
+	"COALESCE(l.settlement_id, '') AS settlement_id, l.settled_at, l.last_refund_at, "
+
+// authOutstandingCond 仍占用卡余额的交易授权：没有清算，也没有全部退款或撤销
+const authOutstandingCond = "l.settlement_id IS NULL AND ABS(t.order_amount) - COALESCE(l.refunded, 0) - COALESCE(l.reversed, 0) > 0"
+
+// GetAuthorizations 分页查询交易授权的生命周期：净额、状态、清算或退回的时间和持续天数。
+// cardNumber、nickname、status 为空时不限制，授权时间范围同 GetTransactions
+func GetAuthorizations(cardNumber string, nickname string, status string, startTime int, endTime int, loc *time.Location, pageSize int, pageNum int) ([]AuthorizationSummary, int64, error) {
+	query := whereTransactionTime(authorizationQuery(db), startTime, endTime, loc)
+	if cardNumber != "" {
+		query = query.Where("t.card_number = ?", cardNumber)
+	}
+	if nickname != "" {
+		query = query.Where("t.nickname = ?", nickname)
+	}
+	if status != "" {
+		query = query.Where(authStatusExpr+" = ?", status)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []AuthorizationSummary
+	err := query.Select(authorizationColumns + authStatusExpr + " AS status").
+		Order("t.transaction_time DESC, t.transaction_id DESC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Scan(&rows).Error
+	now := time.Now()
+	for i := range rows {
+		rows[i].fillLifecycle(now)
+	}
+	return rows, total, err
+}
+
+// AuthorizationDetail 一笔交易授权及与之关联的交易
+type AuthorizationDetail struct {
+	AuthorizationSummary
+	Linked []Transaction `json:"linked"`
+}
+
+// GetAuthorization 查看一笔交易授权的净额、状态和关联的清算、退款、撤销
+func GetAuthorization(id string) (*AuthorizationDetail, error) {
+	var detail AuthorizationDetail
+	result := authorizationQuery(db).Where("t.transaction_id = ?", id).
+		Select(authorizationColumns + authStatusExpr + " AS status").
+		Scan(&detail.AuthorizationSummary)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	detail.fillLifecycle(time.Now())
+	err := db.Where("transaction_id IN (?)", db.Model(&AuthorizationLink{}).Select("linked_id").Where("authorization_id = ?", id)).
+		Order("transaction_time ASC, transaction_id ASC").
+		Find(&detail.Linked).Error
+	if err != nil {
+		return nil, err
+	}
+	return &detail, nil
+}
+
+// GetOrphanRefunds 分页查询没有关联到交易授权的交易退款和交易授权撤销
+func GetOrphanRefunds(cardNumber string, nickname string, pageSize int, pageNum int) ([]Transaction, int64, error) {
+	query := db.Model(&Transaction{}).
+		Where("transaction_type IN ? AND order_amount <> 0", []string{TypeRefund, TypeAuthReversal}).
+		Where("transaction_id NOT IN (?)", db.Model(&AuthorizationLink{}).Select("linked_id"))
+	if cardNumber != "" {
+		query = query.Where("card_number = ?", cardNumber)
+	}
+	if nickname != "" {
+		query = query.Where("nickname = ?", nickname)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var rows []Transaction
+	err := query.Order("transaction_time DESC, transaction_id DESC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Find(&rows).Error
+	return rows, total, err
+}
+
+// StaleAuthorizationGroup 一张卡上超期未清算的交易授权合计
+type StaleAuthorizationGroup struct {
+	Nickname    string    `json:"nickname"`
+	CardNumber  string    `json:"card_number"`
+	Currency    string    `json:"currency"`
+	Count       int       `json:"count"`
+	Amount      Money     `json:"amount"` // 仍占用的金额，即各授权净额之和
+	Oldest      time.Time `json:"oldest"`
+	MaxOpenDays int       `json:"max_open_days"`
+}
+
+// StaleAuthorizationReport 授权超过 Days 天仍未清算、也没有全部退回的交易授权，按卡和昵称汇总
+type StaleAuthorizationReport struct {
+	Days           int                       `json:"days"`
+	AsOf           time.Time                 `json:"as_of"`
+	Groups         []StaleAuthorizationGroup `json:"groups"`
+	Authorizations []AuthorizationSummary    `json:"authorizations"`
+}
+
+// GetStaleAuthorizations 生成超期授权的账龄报表，days 不大于0时使用配置的 StaleDays，cardNumber、nickname 为空时不限制
+func GetStaleAuthorizations(days int, cardNumber string, nickname string) (*StaleAuthorizationReport, error) {
+	if days <= 0 {
+		days = utils.StaleAuthorizationDays
+	}
+	now := time.Now()
+	report := &StaleAuthorizationReport{Days: days, AsOf: now, Groups: []StaleAuthorizationGroup{}, Authorizations: []AuthorizationSummary{}}
+
+	query := authorizationQuery(db).
+		Where(authOutstandingCond).
+		Where("t.transaction_time < ?", now.AddDate(0, 0, -days))
+	if cardNumber != "" {
+		query = query.Where("t.card_number = ?", cardNumber)
+	}
+	if nickname != "" {
+		query = query.Where("t.nickname = ?", nickname)
+	}
+	err := query.Select(authorizationColumns + authStatusExpr + " AS status").
+		Order("t.nickname ASC, t.card_number ASC, t.transaction_time ASC, t.transaction_id ASC").
+		Scan(&report.Authorizations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct{ Nickname, CardNumber, Currency string }
+	groups := map[key]*StaleAuthorizationGroup{}
+	var order []key
+	for i := range report.Authorizations {
+		a := &report.Authorizations[i]
+		a.fillLifecycle(now)
+		k := key{a.Nickname, a.CardNumber, a.Currency}
+		g, ok := groups[k]
+		if !ok {
+			g = &StaleAuthorizationGroup{Nickname: a.Nickname, CardNumber: a.CardNumber, Currency: a.Currency, Oldest: a.TransactionTime}
+			groups[k] = g
+			order = append(order, k)
+		}
+		g.Count++
+		g.Amount += a.NetAmount
+		if a.TransactionTime.Before(g.Oldest) {
+			g.Oldest = a.TransactionTime
+		}
+		if a.OpenDays > g.MaxOpenDays {
+			g.MaxOpenDays = a.OpenDays
+		}
+	}
+	for _, k := range order {
+		report.Groups = append(report.Groups, *groups[k])
+	}
+	// 占用时间最长的卡排在前面
+	sort.SliceStable(report.Groups, func(i, j int) bool { return report.Groups[i].MaxOpenDays > report.Groups[j].MaxOpenDays })
+	return report, nil
+}
+
+// WriteStaleAuthorizationsXLSX 把超期授权报表导出为 XLSX，汇总和明细各一个工作表
+func WriteStaleAuthorizationsXLSX(report *StaleAuthorizationReport, w io.Writer) error {
+	sheets := []xlsxSheet{
+		{name: "汇总", header: []interface{}{"昵称", "卡号", "币种", "笔数", "占用金额", "最早授权时间", "最长天数"}},
+		{name: "明细", header: []interface{}{"昵称", "卡号", "交易ID", "授权时间", "账单名称", "币种", "授权金额", "已退款", "已撤销", "净额", "状态", "天数"}},
+	}
+	for _, g := range report.Groups {
+		sheets[0].rows = append(sheets[0].rows, []interface{}{g.Nickname, g.CardNumber, g.Currency, g.Count, g.Amount.String(),
+			g.Oldest.Format(TransactionTimeLayout), g.MaxOpenDays})
+	}
+	for _, a := range report.Authorizations {
+		sheets[1].rows = append(sheets[1].rows, []interface{}{a.Nickname, a.CardNumber, a.TransactionID, a.TransactionTime.Format(TransactionTimeLayout),
+			a.BillName, a.Currency, a.Amount.String(), a.Refunded.String(), a.Reversed.String(), a.NetAmount.String(), a.Status, a.OpenDays})
+	}
+	return writeXLSXSheets(sheets, w)
+}
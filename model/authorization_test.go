@@ -0,0 +1,244 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLinkAuthorizations(t *testing.T) {
+	base := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	tx := func(id, typ string, offset time.Duration, amount Money, merchant string) Transaction {
+		return Transaction{
+			TransactionID:   id,
+			TransactionType: typ,
+			TransactionTime: base.Add(offset),
+			CardNumber:      "1234",
+			Nickname:        "A",
+			BillName:        merchant,
+			OrderAmount:     amount,
+			OrderCurrency:   "USD",
+		}
+	}
+	day := 24 * time.Hour
+	const settleWindow, refundWindow = 7 * 24 * time.Hour, 30 * 24 * time.Hour
+
+	tests := []struct {
+		name  string
+		trans []Transaction
+		want  []string // 授权编号<-关联编号:金额
+	}{
+		{
+			name: "窗口内金额相同的清算",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("s1", TypeSettlement, 2*day, -10000, ""),
+			},
+			want: []string{"a1<-s1:100.00"},
+		},
+		{
+			name: "清算超出窗口",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("s1", TypeSettlement, 8*day, -10000, ""),
+			},
+		},
+		{
+			name: "金额不同但商户相同的清算",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, "FACEBK ADS"),
+				tx("s1", TypeSettlement, day, -10250, "FACEBK ADS"),
+			},
+			want: []string{"a1<-s1:102.50"},
+		},
+		{
+			name: "金额不同且商户未知的清算不关联",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("s1", TypeSettlement, day, -10250, ""),
+			},
+		},
+		{
+			name: "每笔授权最多一笔清算",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("s1", TypeSettlement, day, -10000, ""),
+				tx("s2", TypeSettlement, 2*day, -10000, ""),
+			},
+			want: []string{"a1<-s1:100.00"},
+		},
+		{
+			name: "多次部分退款不超过尚未退回的金额",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("r1", TypeRefund, day, 3000, ""),
+				tx("r2", TypeAuthReversal, 2*day, 5000, ""),
+				tx("r3", TypeRefund, 3*day, 4000, ""),
+			},
+			want: []string{"a1<-r1:30.00", "a1<-r2:50.00"},
+		},
+		{
+			name: "退款可以恰好退回剩余金额",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("r1", TypeRefund, day, 3000, ""),
+				tx("r2", TypeRefund, 2*day, 7000, ""),
+			},
+			want: []string{"a1<-r1:30.00", "a1<-r2:70.00"},
+		},
+		{
+			name: "退款超出退款窗口",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("r1", TypeRefund, 31*day, 3000, ""),
+			},
+		},
+		{
+			name: "退款最多早于授权一分钟",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("a2", TypeAuthorization, 10*time.Minute, -5000, ""),
+				tx("r1", TypeRefund, -30*time.Second, 10000, ""),
+				tx("r2", TypeRefund, 8*time.Minute, 5000, ""),
+			},
+			want: []string{"a1<-r1:100.00"},
+		},
+		{
+			name: "优先商户相同的授权",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, "GOOGLE ADS"),
+				tx("a2", TypeAuthorization, 5*day, -3000, ""),
+				tx("r1", TypeRefund, 6*day, 3000, "GOOGLE ADS"),
+			},
+			want: []string{"a1<-r1:30.00"},
+		},
+		{
+			name: "商户都不为空且不同时不关联",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, "GOOGLE ADS"),
+				tx("r1", TypeRefund, day, 10000, "FACEBK ADS"),
+			},
+		},
+		{
+			name: "商户相同时优先金额恰好相等的授权",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -3000, ""),
+				tx("a2", TypeAuthorization, 2*day, -5000, ""),
+				tx("r1", TypeRefund, 3*day, 3000, ""),
+			},
+			want: []string{"a1<-r1:30.00"},
+		},
+		{
+			name: "其余相同时优先时间最近的授权",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -5000, ""),
+				tx("a2", TypeAuthorization, 2*day, -5000, ""),
+				tx("r1", TypeRefund, 3*day, 3000, ""),
+			},
+			want: []string{"a2<-r1:30.00"},
+		},
+		{
+			name: "币种不同不关联",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				func() Transaction {
+					r := tx("r1", TypeRefund, day, 10000, "")
+					r.OrderCurrency = "EUR"
+					return r
+				}(),
+			},
+		},
+		{
+			name: "验卡授权不参与关联",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, 0, ""),
+				tx("r1", TypeAuthReversal, time.Minute, 0, ""),
+				tx("r2", TypeRefund, time.Minute, 100, ""),
+			},
+		},
+		{
+			name: "先关联清算，再按时间顺序冲抵退款",
+			trans: []Transaction{
+				tx("a1", TypeAuthorization, 0, -10000, ""),
+				tx("r1", TypeRefund, day, 10000, ""),
+				tx("s1", TypeSettlement, 3*day, -10000, ""),
+			},
+			want: []string{"a1<-s1:100.00", "a1<-r1:100.00"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, l := range linkAuthorizations(tt.trans, settleWindow, refundWindow) {
+				got = append(got, fmt.Sprintf("%s<-%s:%v", l.AuthorizationID, l.LinkedID, l.Amount))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("linkAuthorizations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizationSummaryFillLifecycle(t *testing.T) {
+	authorized := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	at := func(days float64) *time.Time {
+		v := authorized.Add(time.Duration(days * float64(24*time.Hour)))
+		return &v
+	}
+	now := *at(10)
+
+	tests := []struct {
+		name         string
+		s            AuthorizationSummary
+		wantClosedAt *time.Time
+		wantOpenDays int
+	}{
+		{
+			name:         "尚未清算时计到当前时间",
+			s:            AuthorizationSummary{NetAmount: 10000},
+			wantOpenDays: 10,
+		},
+		{
+			name:         "已清算时计到清算时间",
+			s:            AuthorizationSummary{NetAmount: 10000, SettledAt: at(2.5), LastRefundAt: at(4)},
+			wantClosedAt: at(2.5),
+			wantOpenDays: 2,
+		},
+		{
+			name:         "全部退回时计到最后一笔退款",
+			s:            AuthorizationSummary{NetAmount: 0, LastRefundAt: at(3)},
+			wantClosedAt: at(3),
+			wantOpenDays: 3,
+		},
+		{
+			name:         "部分退回时仍未结束",
+			s:            AuthorizationSummary{NetAmount: 2000, LastRefundAt: at(3)},
+			wantOpenDays: 10,
+		},
+		{
+			name:         "不足一天为0",
+			s:            AuthorizationSummary{NetAmount: 10000, SettledAt: at(0.5)},
+			wantClosedAt: at(0.5),
+		},
+		{
+			name:         "退款时间早于授权时为0",
+			s:            AuthorizationSummary{NetAmount: 0, LastRefundAt: at(-0.5)},
+			wantClosedAt: at(-0.5),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.s
+			s.TransactionTime = authorized
+			s.fillLifecycle(now)
+			if (s.ClosedAt == nil) != (tt.wantClosedAt == nil) ||
+				s.ClosedAt != nil && !s.ClosedAt.Equal(*tt.wantClosedAt) {
+				t.Errorf("ClosedAt = %v, want %v", s.ClosedAt, tt.wantClosedAt)
+			}
+			if s.OpenDays != tt.wantOpenDays {
+				t.Errorf("OpenDays = %d, want %d", s.OpenDays, tt.wantOpenDays)
+			}
+		})
+	}
+}
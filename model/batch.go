@@ -0,0 +1,383 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 导入批次的文件类型
+const (
+	ImportKindCard    = "card"    // 虚拟卡交易 XLSX
+	ImportKindBilling = "billing" // 广告平台账单 CSV
+)
+
+// ImportOptions 导入时附带的来源信息
+type ImportOptions struct {
+	FileName string                         // 上传时的原始文件名，为空时取保存路径的文件名
+	Uploader string                         // 上传人
+	Provider string                         // 虚拟卡供应商或广告平台，虚拟卡文件为空时取 DefaultCardProvider
+	Progress func(processed int, total int) // 进度回调，可为空
+
+	// BestEffort 为 false（默认）时整个文件全部写入或全部不写入：有被拒绝的行
+	// 或总计不符时回滚整个导入；为 true 时写入可以导入的行，被拒绝的行只做记录
+	BestEffort bool
+}
+
+// progressStep 每处理多少行汇报一次进度
+const progressStep = 200
+
+// importProgress 按行累计导入进度并定期回调，total 为总行数或其估计值，为0表示未知
+type importProgress struct {
+	fn       func(processed int, total int)
+	total    int
+	done     int
+	reported int
+}
+
+func newImportProgress(fn func(processed int, total int), total int) *importProgress {
+	return &importProgress{fn: fn, total: total}
+}
+
+// add 累计 n 行，距上次汇报满 progressStep 行或全部完成时回调。
+// 估计的总行数偏少时，总行数随已处理的行数增加
+func (p *importProgress) add(n int) {
+	p.done += n
+	if p.total > 0 && p.done > p.total {
+		p.total = p.done
+	}
+	if p.fn == nil || n == 0 {
+		return
+	}
+	if p.done-p.reported >= progressStep || (p.total > 0 && p.done >= p.total) {
+		p.reported = p.done
+		p.fn(p.done, p.total)
+	}
+}
+
+// ImportBatch 一次文件导入，记录来源文件、上传人及各类行数
+type ImportBatch struct {
+	ID                uint              `gorm:"primaryKey" json:"id"`
+	Kind              string            `gorm:"type:varchar(20);index" json:"kind"`
+	Provider          string            `gorm:"type:varchar(50)" json:"provider"`
+	FileName          string            `gorm:"type:varchar(255)" json:"file_name"`
+	FileHash          string            `gorm:"type:char(64);index" json:"file_hash"` // SHA-256
+	Uploader          string            `gorm:"type:varchar(100)" json:"uploader"`
+	CreatedAt         time.Time         `json:"created_at"`
+	TotalRows         int               `gorm:"type:int" json:"total_rows"`
+	InsertedRows      int               `gorm:"type:int" json:"inserted_rows"`
+	ExistingRows      int               `gorm:"type:int" json:"existing_rows"`
+	UpdatedRows       int               `gorm:"type:int" json:"updated_rows"`        // 已存在的行中字段有变化并已更新的行数
+	StatusChangedRows int               `gorm:"type:int" json:"status_changed_rows"` // 其中交易状态或清算状态有变化的行数
+	SkippedRows       int               `gorm:"type:int" json:"skipped_rows"`        // 被拒绝的行数，明细见 ImportReject
+	WarningRows       int               `gorm:"type:int" json:"warning_rows"`        // 已导入但数据可疑的行数
+	MatchedRows       int               `gorm:"type:int" json:"matched_rows"`        // 匹配到交易清算的账单行数
+	RolledBack        bool              `gorm:"type:boolean" json:"rolled_back"`
+	RolledBackAt      *time.Time        `json:"rolled_back_at"`
+	Header            []string          `gorm:"serializer:json;type:text" json:"-"`        // 源文件标题行，导出拒绝行时使用
+	Accounts          []AccountRowCount `gorm:"serializer:json;type:text" json:"accounts"` // 按广告账户统计的行数（仅账单文件）
+	TotalsMismatch    bool              `gorm:"type:boolean" json:"totals_mismatch"`       // 有账户的总计行与导入金额不符
+	BestEffort        bool              `gorm:"type:boolean" json:"best_effort"`
+	Error             string            `gorm:"type:varchar(1000)" json:"error"`   // 导入被取消的原因，不为空时本批次没有写入任何数据
+	Running           bool              `gorm:"type:boolean;index" json:"running"` // 仍在导入。写入事务提交时清除，服务中途退出时由 StartImportWorker 标记为取消
+
+	rejects []RejectedRow
+}
+
+// AccountRowCount 账单文件中一个广告账户的行数统计，以及总计行金额与实际导入金额的核对结果
+type AccountRowCount struct {
+	Account      string `json:"account"`
+	Rows         int    `json:"rows"`
+	Inserted     int    `json:"inserted"`
+	Existing     int    `json:"existing"`
+	Skipped      int    `json:"skipped"`
+	Matched      int    `json:"matched"`
+	Billed       Money  `json:"billed"`        // 总计行金额之和
+	Imported     Money  `json:"imported"`      // 新增及已存在的行金额之和
+	TotalChecked bool   `json:"total_checked"` // 账单格式带有总计行，需要核对
+	TotalMissing bool   `json:"total_missing"` // 有区块没有可解析的总计行
+	Mismatch     bool   `json:"mismatch"`
+}
+
+// accountCounter 按账户在文件中出现的顺序累计行数
+type accountCounter struct {
+	list  []AccountRowCount
+	index map[string]int
+}
+
+func newAccountCounter(sections []*BillingSection) *accountCounter {
+	c := &accountCounter{index: make(map[string]int)}
+	for _, s := range sections {
+		count := c.get(s.Account)
+		count.TotalChecked = true
+		count.Billed += s.Total
+		if !s.HasTotal {
+			count.TotalMissing = true
+		}
+	}
+	return c
+}
+
+// checkTotals 比较每个账户的总计金额与导入金额，返回是否有账户不符
+func (c *accountCounter) checkTotals() bool {
+	mismatch := false
+	for i := range c.list {
+		count := &c.list[i]
+		count.Mismatch = count.TotalChecked && (count.TotalMissing || count.Billed != count.Imported)
+		mismatch = mismatch || count.Mismatch
+	}
+	return mismatch
+}
+
+func (c *accountCounter) get(account string) *AccountRowCount {
+	i, ok := c.index[account]
+	if !ok {
+		i = len(c.list)
+		c.index[account] = i
+		c.list = append(c.list, AccountRowCount{Account: account})
+	}
+	return &c.list[i]
+}
+
+// fileSHA256 计算文件内容的 SHA-256
+func fileSHA256(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// newImportBatch 为即将导入的文件创建批次记录
+func newImportBatch(kind string, filePath string, opts ImportOptions) (*ImportBatch, error) {
+	hash, err := fileSHA256(filePath)
+	if err != nil {
+		return nil, err
+	}
+	batch := &ImportBatch{
+		Kind:       kind,
+		FileName:   opts.FileName,
+		FileHash:   hash,
+		Uploader:   opts.Uploader,
+		Provider:   opts.Provider,
+		BestEffort: opts.BestEffort,
+		Running:    true,
+	}
+	if batch.FileName == "" {
+		batch.FileName = filepath.Base(filePath)
+	}
+	if err := db.Create(batch).Error; err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// reject 记录一条被拒绝或可疑的行
+func (b *ImportBatch) reject(r RejectedRow) {
+	if r.Warning {
+		b.WarningRows++
+	} else {
+		b.SkippedRows++
+	}
+	b.rejects = append(b.rejects, r)
+}
+
+// write 在一个数据库事务中执行批次的全部写入。fn 返回错误，或全部写入模式下
+// 有被拒绝的行、总计不符时，整个事务回滚，批次记录取消原因；
+// 无论成功与否都保存批次及拒绝行明细，便于下载拒绝行核对原因
+func (b *ImportBatch) write(fn func(tx *gorm.DB) error) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if err := b.verify(); err != nil {
+			return err
+		}
+		// 与写入一起提交，之后退出时批次不会被误标为取消
+		return tx.Model(&ImportBatch{}).Where("id = ?", b.ID).Update("running", false).Error
+	})
+	if err != nil {
+		b.Error = err.Error()
+		if len([]rune(b.Error)) > 1000 {
+			b.Error = string([]rune(b.Error)[:1000])
+		}
+		// 事务已回滚，新增和匹配的行都没有写入
+		b.InsertedRows, b.MatchedRows = 0, 0
+		b.UpdatedRows, b.StatusChangedRows = 0, 0
+		for i := range b.Accounts {
+			b.Accounts[i].Inserted, b.Accounts[i].Matched = 0, 0
+		}
+	}
+	if saveErr := b.finish(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// verify 全部写入模式下检查批次能否提交
+func (b *ImportBatch) verify() error {
+	if b.BestEffort {
+		return nil
+	}
+	if b.SkippedRows > 0 {
+		return fmt.Errorf("%d 行被拒绝，导入已取消，未写入任何数据", b.SkippedRows)
+	}
+	if b.TotalsMismatch {
+		return errors.New("账单总计行与导入金额不符，导入已取消，未写入任何数据")
+	}
+	return nil
+}
+
+// finish 保存批次的最终行数及拒绝行明细
+func (b *ImportBatch) finish() error {
+	if err := saveImportRejects(b.ID, b.rejects); err != nil {
+		return err
+	}
+	b.rejects = nil
+	b.Running = false
+	return db.Save(b).Error
+}
+
+// errImportInterrupted 服务在导入过程中退出，写入事务没有提交
+const errImportInterrupted = "导入中断：服务在导入过程中退出，本批次没有写入任何数据"
+
+// cancelInterruptedBatches 把上次退出时仍在导入的批次标记为取消。只在启动时调用，此时没有正在执行的导入
+func cancelInterruptedBatches() error {
+	return db.Model(&ImportBatch{}).Where("running = ?", true).
+		Updates(map[string]interface{}{"running": false, "error": errImportInterrupted}).Error
+}
+
+// GetImportBatches 分页查询导入批次，kind 为空时查询全部
+func GetImportBatches(kind string, pageSize int, pageNum int) ([]ImportBatch, int64, error) {
+	var batches []ImportBatch
+	var total int64
+	query := db.Model(&ImportBatch{})
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id DESC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Find(&batches).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return batches, total, nil
+}
+
+// GetImportBatch 查询单个导入批次
+func GetImportBatch(id uint) (ImportBatch, error) {
+	var batch ImportBatch
+	err := db.First(&batch, id).Error
+	return batch, err
+}
+
+// GetBatchTransactions 分页查询某批次导入的虚拟卡交易
+func GetBatchTransactions(id uint, pageSize int, pageNum int) ([]Transaction, int64, error) {
+	var rows []Transaction
+	var total int64
+	query := db.Model(&Transaction{}).Where("batch_id = ?", id)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("source_row ASC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Find(&rows).Error
+	return rows, total, err
+}
+
+// GetBatchTransactionRecords 分页查询某批次导入的账单记录
+func GetBatchTransactionRecords(id uint, pageSize int, pageNum int) ([]TransactionRecord, int64, error) {
+	var rows []TransactionRecord
+	var total int64
+	query := db.Model(&TransactionRecord{}).Where("batch_id = ?", id)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("source_row ASC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Find(&rows).Error
+	return rows, total, err
+}
+
+// RollbackImportBatch 回滚一个导入批次：删除它自动匹配的结果及其插入的行上的匹配，
+// 恢复旧版批次修改过的 is_judge / is_trading_authorization 标记和重新导入时更新的交易字段，并删除它插入的所有行
+func RollbackImportBatch(id uint) (*ImportBatch, error) {
+	var batch ImportBatch
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&batch, id).Error; err != nil {
+			return err
+		}
+		if batch.Running {
+			return fmt.Errorf("批次 %d 仍在导入", batch.ID)
+		}
+		if batch.Error != "" {
+			return fmt.Errorf("批次 %d 导入时已取消，没有需要回滚的数据", batch.ID)
+		}
+		if batch.RolledBack {
+			return fmt.Errorf("批次 %d 已于 %s 回滚", batch.ID, batch.RolledBackAt.Format("2006-01-02 15:04:05"))
+		}
+		if err := checkBatchPeriods(tx, batch); err != nil {
+			return err
+		}
+
+		var err error
+		switch batch.Kind {
+		case ImportKindCard:
+			if err := restoreTransactionChanges(tx, id); err != nil {
+				return err
+			}
+			// 被删除的交易清算上的匹配随之删除，对应的账单恢复为未核对
+			if err := deleteMatchesFor(tx, "settlement_id IN (?)", tx.Model(&Transaction{}).Select("transaction_id").Where("batch_id = ?", id)); err != nil {
+				return err
+			}
+			cards, cardsErr := transactionCards(tx, "batch_id = ?", id)
+			if cardsErr != nil {
+				return cardsErr
+			}
+			if err := tx.Where("batch_id = ?", id).Delete(&Transaction{}).Error; err != nil {
+				return err
+			}
+			// 剩余交易重新关联
+			err = relinkAuthorizations(tx, cards)
+		case ImportKindBilling:
+			// 该批次自动匹配的结果和被删除的账单上的匹配
+			if err := deleteMatchesFor(tx, "batch_id = ? OR record_id IN (?)", id, tx.Model(&TransactionRecord{}).Select("transaction_id").Where("batch_id = ?", id)); err != nil {
+				return err
+			}
+			err = tx.Where("batch_id = ?", id).Delete(&TransactionRecord{}).Error
+		default:
+			err = errors.New("未知的批次类型: " + batch.Kind)
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		batch.RolledBack = true
+		batch.RolledBackAt = &now
+		return tx.Save(&batch).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
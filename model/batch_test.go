@@ -0,0 +1,136 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAccountCounterCheckTotals(t *testing.T) {
+	tests := []struct {
+		name         string
+		sections     []*BillingSection
+		imported     map[string]Money
+		wantMismatch bool
+		want         map[string]bool // 各账户是否不符
+	}{
+		{
+			name:     "总计与导入金额一致",
+			sections: []*BillingSection{{Account: "A", Total: 1000, HasTotal: true}},
+			imported: map[string]Money{"A": 1000},
+			want:     map[string]bool{"A": false},
+		},
+		{
+			name:         "同一账户多个区块的总计相加后比较",
+			sections:     []*BillingSection{{Account: "A", Total: 600, HasTotal: true}, {Account: "B", Total: 5, HasTotal: true}, {Account: "A", Total: 400, HasTotal: true}},
+			imported:     map[string]Money{"A": 1000, "B": 4},
+			wantMismatch: true,
+			want:         map[string]bool{"A": false, "B": true},
+		},
+		{
+			name:         "缺少总计行视为不符",
+			sections:     []*BillingSection{{Account: "A", Total: 0, HasTotal: false}},
+			imported:     map[string]Money{"A": 0},
+			wantMismatch: true,
+			want:         map[string]bool{"A": true},
+		},
+		{
+			name:     "没有总计行的格式不核对",
+			imported: map[string]Money{"A": 1000},
+			want:     map[string]bool{"A": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newAccountCounter(tt.sections)
+			for account, amount := range tt.imported {
+				c.get(account).Imported += amount
+			}
+			if got := c.checkTotals(); got != tt.wantMismatch {
+				t.Errorf("checkTotals() = %v, want %v", got, tt.wantMismatch)
+			}
+			for account, want := range tt.want {
+				if got := c.get(account).Mismatch; got != want {
+					t.Errorf("account %s mismatch = %v, want %v", account, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestImportProgress(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		adds  []int
+		want  [][2]int // 每次回调的 已处理、总行数
+	}{
+		{
+			name:  "总行数已知时满 progressStep 行及全部完成时汇报",
+			total: 450,
+			adds:  []int{150, 100, 199, 1},
+			want:  [][2]int{{250, 450}, {450, 450}},
+		},
+		{
+			name:  "估计偏多时只按步长汇报，总行数不变",
+			total: 1000,
+			adds:  []int{300, 50},
+			want:  [][2]int{{300, 1000}},
+		},
+		{
+			name:  "估计偏少时总行数随已处理的行数增加",
+			total: 100,
+			adds:  []int{80, 40, 200},
+			want:  [][2]int{{120, 120}, {320, 320}},
+		},
+		{
+			name: "总行数未知",
+			adds: []int{150, 150},
+			want: [][2]int{{300, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][2]int
+			p := newImportProgress(func(processed, total int) { got = append(got, [2]int{processed, total}) }, tt.total)
+			for _, n := range tt.adds {
+				p.add(n)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reports = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCancelInterruptedBatches 启动时仍在导入的批次标记为取消，已完成的批次不受影响，需要设置 WB_BENCH_DSN
+func TestCancelInterruptedBatches(t *testing.T) {
+	openBenchDB(t, &ImportBatch{}, &ImportReject{})
+
+	interrupted := ImportBatch{Kind: ImportKindCard, FileName: "interrupted.xlsx", Running: true}
+	finished := ImportBatch{Kind: ImportKindCard, FileName: "finished.xlsx"}
+	for _, b := range []*ImportBatch{&interrupted, &finished} {
+		if err := db.Create(b).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { db.Delete(&ImportBatch{}, []uint{interrupted.ID, finished.ID}) })
+
+	if _, err := RollbackImportBatch(interrupted.ID); err == nil {
+		t.Error("RollbackImportBatch() on a running batch: want error")
+	}
+	if err := cancelInterruptedBatches(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		id        uint
+		wantError string
+	}{{interrupted.ID, errImportInterrupted}, {finished.ID, ""}} {
+		b, err := GetImportBatch(tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.Running || b.Error != tt.wantError {
+			t.Errorf("batch %s: running %v, error %q, want false, %q", b.FileName, b.Running, b.Error, tt.wantError)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package model
 
 import (
+	"app/utils"
 	"fmt"
-	"github.com/wejectchen/ginblog/utils"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -44,7 +44,21 @@ func InitDb() {
 
 	// 迁移数据表，在没有数据表结构变更时候，建议注释不执行
 	// 注意:初次运行后可注销此行
-	_ = db.AutoMigrate(&User{},Profile{},&Transaction{},&TransactionRecord{})
+	// 旧版的交易时间、账单日期是字符串，修改列类型前先整理数据
+	if err := migrateTimeColumns(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_ = db.AutoMigrate(&User{},Profile{},&Transaction{},&TransactionRecord{},&ImportBatch{},&ImportJob{},&ImportReject{},&TransactionChange{},&FxRate{},&LedgerRule{},&Reconciliation{},&AuthorizationLink{},&PeriodLock{},&PeriodLockEvent{})
+	if err := backfillReconciliations(); err != nil {
+		fmt.Println("初始化匹配表失败：", err)
+	}
+	if err := backfillAuthorizationLinks(); err != nil {
+		fmt.Println("初始化交易授权关联失败：", err)
+	}
+	if err := seedLedgerRules(); err != nil {
+		fmt.Println("初始化余额规则失败：", err)
+	}
 
 	sqlDB, _ := db.DB()
 	// SetMaxIdleCons 设置连接池中的最大闲置连接数。
@@ -0,0 +1,266 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+)
+
+// ExceptionTotal 一个广告账户、一种币种在当月两边的合计，金额均为绝对值
+type ExceptionTotal struct {
+	Account                   string `json:"account"`
+	Currency                  string `json:"currency"`
+	ChargeCount               int64  `json:"charge_count"`
+	ChargeAmount              Money  `json:"charge_amount"`
+	UnmatchedChargeCount      int64  `json:"unmatched_charge_count"`
+	UnmatchedChargeAmount     Money  `json:"unmatched_charge_amount"`
+	SettlementCount           int64  `json:"settlement_count"`
+	SettlementAmount          Money  `json:"settlement_amount"`
+	UnmatchedSettlementCount  int64  `json:"unmatched_settlement_count"`
+	UnmatchedSettlementAmount Money  `json:"unmatched_settlement_amount"`
+	Difference                Money  `json:"difference"` // 账单合计减交易清算合计
+}
+
+// AmountMismatch 已匹配但金额不一致的一笔交易清算及其账单
+type AmountMismatch struct {
+	SettlementID     string    `json:"settlement_id"`
+	Account          string    `json:"account"`
+	CardNumber       string    `json:"card_number"`
+	SettlementTime   time.Time `json:"settlement_time"`
+	Currency         string    `json:"currency"`
+	SettlementAmount Money     `json:"settlement_amount"`
+	ChargeAmount     Money     `json:"charge_amount"`
+	Difference       Money     `json:"difference"` // 账单合计减清算金额
+	RecordIDs        []string  `json:"record_ids"`
+	Method           string    `json:"method"`
+}
+
+// ExceptionReport 广告账户某月的核对差异：没有交易清算的账单、没有账单的交易清算、
+// 金额不一致的匹配以及两边的合计。账单按自身的账单日期（平台时区）、交易清算按 loc 中的交易时间归入月份，
+// 与结账期间的划分一致
+type ExceptionReport struct {
+	Account              string              `json:"account"` // 为空表示所有账户
+	Month                string              `json:"month"`   // 2006-01
+	Totals               []ExceptionTotal    `json:"totals"`
+	UnmatchedCharges     []TransactionRecord `json:"unmatched_charges"`
+	UnmatchedSettlements []Transaction       `json:"unmatched_settlements"`
+	Mismatches           []AmountMismatch    `json:"mismatches"`
+}
+
+// GetExceptionReport 生成广告账户某月的核对差异报表，account 为空时包含所有账户，月份边界按 loc 划分
+func GetExceptionReport(account string, year int, month int, loc *time.Location) (*ExceptionReport, error) {
+	if month < 1 || month > 12 || year <= 0 {
+		return nil, errors.New("无效的年份或月份")
+	}
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, loc)
+	// whereTransactionTime 按日期划分，结束时间取当月最后一秒
+	startTime, endTime := int(start.Unix()), int(start.AddDate(0, 1, 0).Unix())-1
+	report := &ExceptionReport{
+		Account:              account,
+		Month:                start.Format("2006-01"),
+		Totals:               []ExceptionTotal{},
+		UnmatchedCharges:     []TransactionRecord{},
+		UnmatchedSettlements: []Transaction{},
+		Mismatches:           []AmountMismatch{},
+	}
+
+	records := func() *gorm.DB {
+		query := db.Model(&TransactionRecord{}).
+			Where("date >= ? AND date < ?", start.Format(DateLayout), start.AddDate(0, 1, 0).Format(DateLayout))
+		if account != "" {
+			query = query.Where("account = ?", account)
+		}
+		return query
+	}
+	settlements := func() *gorm.DB {
+		query := db.Model(&Transaction{}).Where("transaction_type = ?", TypeSettlement)
+		if account != "" {
+			query = query.Where("nickname = ?", account)
+		}
+		return whereTransactionTime(query, startTime, endTime, loc)
+	}
+
+	var chargeTotals []ExceptionTotal
+	err := records().
+		Select("account, UPPER(currency) AS currency, COUNT(*) AS charge_count, SUM(ABS(amount)) AS charge_amount, " +
+			"SUM(CASE WHEN is_trading_authorization THEN 0 ELSE 1 END) AS unmatched_charge_count, " +
+			"SUM(CASE WHEN is_trading_authorization THEN 0 ELSE ABS(amount) END) AS unmatched_charge_amount").
+		Group("account, UPPER(currency)").
+		Scan(&chargeTotals).Error
+	if err != nil {
+		return nil, err
+	}
+	var settlementTotals []ExceptionTotal
+	err = settlements().
+		Select("nickname AS account, UPPER(order_currency) AS currency, COUNT(*) AS settlement_count, SUM(ABS(order_amount)) AS settlement_amount, " +
+			"SUM(CASE WHEN is_judge THEN 0 ELSE 1 END) AS unmatched_settlement_count, " +
+			"SUM(CASE WHEN is_judge THEN 0 ELSE ABS(order_amount) END) AS unmatched_settlement_amount").
+		Group("nickname, UPPER(order_currency)").
+		Scan(&settlementTotals).Error
+	if err != nil {
+		return nil, err
+	}
+	report.Totals = mergeExceptionTotals(chargeTotals, settlementTotals)
+
+	if err := records().Where("is_trading_authorization = ?", false).
+		Order("account ASC, date ASC, transaction_id ASC").
+		Find(&report.UnmatchedCharges).Error; err != nil {
+		return nil, err
+	}
+	if err := settlements().Where("is_judge = ?", false).
+		Order("nickname ASC, transaction_time ASC, transaction_id ASC").
+		Find(&report.UnmatchedSettlements).Error; err != nil {
+		return nil, err
+	}
+
+	// 金额不一致的匹配按交易清算所在的月份归属
+	var found []Transaction
+	if err := settlements().Where("is_judge = ?", true).
+		Where("transaction_id IN (?)", db.Model(&Reconciliation{}).Select("settlement_id").
+			Where("revoked_at IS NULL AND difference <> 0")).
+		Order("nickname ASC, transaction_time ASC, transaction_id ASC").
+		Find(&found).Error; err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return report, nil
+	}
+	ids := make([]string, 0, len(found))
+	for _, t := range found {
+		ids = append(ids, t.TransactionID)
+	}
+	var matches []Reconciliation
+	if err := db.Where("revoked_at IS NULL AND settlement_id IN ?", ids).
+		Order("id ASC").Find(&matches).Error; err != nil {
+		return nil, err
+	}
+	bySettlement := map[string][]Reconciliation{}
+	for _, m := range matches {
+		bySettlement[m.SettlementID] = append(bySettlement[m.SettlementID], m)
+	}
+	for _, t := range found {
+		rows := bySettlement[t.TransactionID]
+		if len(rows) == 0 {
+			continue
+		}
+		mismatch := AmountMismatch{
+			SettlementID:     t.TransactionID,
+			Account:          rows[0].Account,
+			CardNumber:       t.CardNumber,
+			SettlementTime:   t.TransactionTime,
+			Currency:         strings.ToUpper(t.OrderCurrency),
+			SettlementAmount: t.OrderAmount.Abs(),
+			Difference:       rows[0].Difference,
+			Method:           rows[0].Method,
+		}
+		mismatch.ChargeAmount = mismatch.SettlementAmount + mismatch.Difference
+		for _, r := range rows {
+			mismatch.RecordIDs = append(mismatch.RecordIDs, r.RecordID)
+		}
+		report.Mismatches = append(report.Mismatches, mismatch)
+	}
+	return report, nil
+}
+
+// mergeExceptionTotals 按账户和币种合并两边的合计
+func mergeExceptionTotals(charges []ExceptionTotal, settlements []ExceptionTotal) []ExceptionTotal {
+	type key struct{ Account, Currency string }
+	merged := map[key]*ExceptionTotal{}
+	get := func(account, currency string) *ExceptionTotal {
+		k := key{account, currency}
+		if merged[k] == nil {
+			merged[k] = &ExceptionTotal{Account: account, Currency: currency}
+		}
+		return merged[k]
+	}
+	for _, c := range charges {
+		t := get(c.Account, c.Currency)
+		t.ChargeCount, t.ChargeAmount = c.ChargeCount, c.ChargeAmount
+		t.UnmatchedChargeCount, t.UnmatchedChargeAmount = c.UnmatchedChargeCount, c.UnmatchedChargeAmount
+	}
+	for _, s := range settlements {
+		t := get(s.Account, s.Currency)
+		t.SettlementCount, t.SettlementAmount = s.SettlementCount, s.SettlementAmount
+		t.UnmatchedSettlementCount, t.UnmatchedSettlementAmount = s.UnmatchedSettlementCount, s.UnmatchedSettlementAmount
+	}
+	totals := make([]ExceptionTotal, 0, len(merged))
+	for _, t := range merged {
+		t.Difference = t.ChargeAmount - t.SettlementAmount
+		totals = append(totals, *t)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Account != totals[j].Account {
+			return totals[i].Account < totals[j].Account
+		}
+		return totals[i].Currency < totals[j].Currency
+	})
+	return totals
+}
+
+// WriteExceptionReportXLSX 把核对差异报表导出为 XLSX，汇总、账单未匹配、清算未匹配、金额不一致各一个工作表
+func WriteExceptionReportXLSX(report *ExceptionReport, w io.Writer) error {
+	sheets := []xlsxSheet{
+		{name: "汇总", header: []interface{}{"账户", "币种", "账单笔数", "账单金额", "未匹配账单笔数", "未匹配账单金额",
+			"清算笔数", "清算金额", "未匹配清算笔数", "未匹配清算金额", "差额"}},
+		{name: "账单未匹配", header: []interface{}{"账户", "日期", "交易ID", "支付方式", "金额", "币种", "平台", "备注"}},
+		{name: "清算未匹配", header: []interface{}{"账户", "交易时间", "交易ID", "卡号", "账单名称", "金额", "币种"}},
+		{name: "金额不一致", header: []interface{}{"账户", "交易清算ID", "卡号", "清算时间", "币种", "清算金额", "账单金额", "差额", "账单交易ID", "匹配方式"}},
+	}
+	for _, t := range report.Totals {
+		sheets[0].rows = append(sheets[0].rows, []interface{}{t.Account, t.Currency, t.ChargeCount, t.ChargeAmount.String(),
+			t.UnmatchedChargeCount, t.UnmatchedChargeAmount.String(), t.SettlementCount, t.SettlementAmount.String(),
+			t.UnmatchedSettlementCount, t.UnmatchedSettlementAmount.String(), t.Difference.String()})
+	}
+	for _, r := range report.UnmatchedCharges {
+		sheets[1].rows = append(sheets[1].rows, []interface{}{r.Account, string(r.Date), r.TransactionID, r.PaymentMethod,
+			r.Amount.String(), r.Currency, r.Platform, r.Note})
+	}
+	for _, t := range report.UnmatchedSettlements {
+		sheets[2].rows = append(sheets[2].rows, []interface{}{t.Nickname, t.TransactionTime.Format(TransactionTimeLayout),
+			t.TransactionID, t.CardNumber, t.BillName, t.OrderAmount.String(), t.OrderCurrency})
+	}
+	for _, m := range report.Mismatches {
+		sheets[3].rows = append(sheets[3].rows, []interface{}{m.Account, m.SettlementID, m.CardNumber,
+			m.SettlementTime.Format(TransactionTimeLayout), m.Currency, m.SettlementAmount.String(), m.ChargeAmount.String(),
+			m.Difference.String(), strings.Join(m.RecordIDs, ","), m.Method})
+	}
+	return writeXLSXSheets(sheets, w)
+}
+
+// xlsxSheet 导出报表中的一个工作表
+type xlsxSheet struct {
+	name   string
+	header []interface{}
+	rows   [][]interface{}
+}
+
+// writeXLSXSheets 依次写入各工作表，第一行为标题行
+func writeXLSXSheets(sheets []xlsxSheet, w io.Writer) error {
+	f := excelize.NewFile()
+	defer f.Close()
+	for i, s := range sheets {
+		if i == 0 {
+			if err := f.SetSheetName(f.GetSheetName(0), s.name); err != nil {
+				return err
+			}
+		} else if _, err := f.NewSheet(s.name); err != nil {
+			return err
+		}
+		if err := f.SetSheetRow(s.name, "A1", &s.header); err != nil {
+			return err
+		}
+		for j, row := range s.rows {
+			cell, _ := excelize.CoordinatesToCellName(1, j+2)
+			if err := f.SetSheetRow(s.name, cell, &row); err != nil {
+				return fmt.Errorf("写入%s失败: %w", s.name, err)
+			}
+		}
+	}
+	return f.Write(w)
+}
@@ -0,0 +1,332 @@
+package model
+
+import (
+	"app/utils"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FxRate 每日汇率：Date 当天 1 单位 From 币种可以兑换 Rate 单位 To 币种
+type FxRate struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Date string `gorm:"type:varchar(10);uniqueIndex:idx_fx_rate" json:"date"` // 2006-01-02
+	From string `gorm:"column:from_currency;type:varchar(10);uniqueIndex:idx_fx_rate" json:"from"`
+	To   string `gorm:"column:to_currency;type:varchar(10);uniqueIndex:idx_fx_rate" json:"to"`
+	Rate string `gorm:"type:decimal(20,10)" json:"rate"` // 十进制字符串，换算时不经过浮点数
+}
+
+// normalize 校验并规范化一条汇率：币种大写、日期为 2006-01-02、汇率为正数
+func (r *FxRate) normalize() error {
+	r.From = strings.ToUpper(strings.TrimSpace(r.From))
+	r.To = strings.ToUpper(strings.TrimSpace(r.To))
+	if r.From == "" || r.To == "" || r.From == r.To {
+		return fmt.Errorf("币种无效: %q → %q", r.From, r.To)
+	}
+	date, err := parseRateDate(strings.TrimSpace(r.Date))
+	if err != nil {
+		return fmt.Errorf("日期无法解析: %q", r.Date)
+	}
+	r.Date = date
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(r.Rate))
+	if !ok || rate.Sign() <= 0 {
+		return fmt.Errorf("汇率无效: %q", r.Rate)
+	}
+	r.Rate = rate.FloatString(10)
+	return nil
+}
+
+func parseRateDate(s string) (string, error) {
+	for _, layout := range []string{"2006-01-02", "2006/01/02", "01/02/2006", "20060102"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("failed to parse date: %q", s)
+}
+
+// SaveFxRates 校验并保存汇率，同一天同一币种对的汇率以新值为准。有任何一行无效时不保存
+func SaveFxRates(rates []FxRate) (int, error) {
+	for i := range rates {
+		if err := rates[i].normalize(); err != nil {
+			return 0, fmt.Errorf("第%d条汇率%v", i+1, err)
+		}
+	}
+	if len(rates) == 0 {
+		return 0, errors.New("没有汇率数据")
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "date"}, {Name: "from_currency"}, {Name: "to_currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate"}),
+	}).CreateInBatches(&rates, 500).Error
+	if err != nil {
+		return 0, err
+	}
+	return len(rates), nil
+}
+
+// fxColumns 汇率 CSV 的列
+var fxColumns = map[string][]string{
+	"Date": {"Date", "日期"},
+	"From": {"From", "Currency", "币种", "源币种"},
+	"To":   {"To", "Base", "目标币种"},
+	"Rate": {"Rate", "汇率"},
+}
+
+// ImportFxRatesFromCSV 从 CSV 导入汇率，第一行为标题行，需要 Date、From、To、Rate 四列
+func ImportFxRatesFromCSV(filePath string) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return 0, fmt.Errorf("汇率文件没有标题行: %v", err)
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		for field, names := range fxColumns {
+			for _, n := range names {
+				if strings.EqualFold(name, n) {
+					if _, ok := index[field]; !ok {
+						index[field] = i
+					}
+				}
+			}
+		}
+	}
+	for _, field := range []string{"Date", "From", "To", "Rate"} {
+		if _, ok := index[field]; !ok {
+			return 0, fmt.Errorf("汇率文件缺少必需列: %s", strings.Join(fxColumns[field], "/"))
+		}
+	}
+
+	var rates []FxRate
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if len(strings.Join(record, "")) == 0 {
+			continue
+		}
+		get := func(field string) string {
+			if i := index[field]; i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		rates = append(rates, FxRate{Date: get("Date"), From: get("From"), To: get("To"), Rate: get("Rate")})
+	}
+	return SaveFxRates(rates)
+}
+
+// GetFxRates 分页查询汇率，currency 不为空时只查询涉及该币种的汇率
+func GetFxRates(currency string, pageSize int, pageNum int) ([]FxRate, int64, error) {
+	var rates []FxRate
+	var total int64
+	query := db.Model(&FxRate{})
+	if currency != "" {
+		currency = strings.ToUpper(currency)
+		query = query.Where("from_currency = ? OR to_currency = ?", currency, currency)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("date DESC, from_currency ASC, to_currency ASC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Find(&rates).Error
+	return rates, total, err
+}
+
+// fxRates 预先加载的汇率，按币种和日期取用，换算多行金额时不再逐个币种逐天查询
+type fxRates struct {
+	target string
+	rates  map[string]*big.Rat // 币种/日期 → 汇率，缺少汇率时没有对应的键
+}
+
+// loadFxRates 一次查询 rows 中各币种各日期换算为 target 所需的汇率。target 为空时不换算，不需要汇率
+func loadFxRates(rows []currencyAmount, target string) (*fxRates, error) {
+	target = strings.ToUpper(target)
+	var currencies []string
+	maxDay := ""
+	seen := map[string]bool{}
+	for _, r := range rows {
+		currency := strings.ToUpper(r.Currency)
+		if target == "" || currency == "" || currency == target {
+			continue
+		}
+		if !seen[currency] {
+			seen[currency] = true
+			currencies = append(currencies, currency)
+		}
+		if r.Day > maxDay {
+			maxDay = r.Day
+		}
+	}
+	var stored []FxRate
+	if len(currencies) > 0 {
+		err := db.Where("((from_currency IN ? AND to_currency = ?) OR (from_currency = ? AND to_currency IN ?)) AND date <= ?",
+			currencies, target, target, currencies, maxDay).
+			Order("date ASC").Find(&stored).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newFxRates(rows, target, stored), nil
+}
+
+// newFxRates 从 stored 中为 rows 的每个币种和日期取当天或之前最近一天的汇率，
+// 没有 币种 → target 的汇率时取反向汇率的倒数。stored 需按日期升序排列
+func newFxRates(rows []currencyAmount, target string, stored []FxRate) *fxRates {
+	target = strings.ToUpper(target)
+	forward := map[string][]FxRate{}
+	reverse := map[string][]FxRate{}
+	for _, r := range stored {
+		if r.To == target {
+			forward[r.From] = append(forward[r.From], r)
+		} else if r.From == target {
+			reverse[r.To] = append(reverse[r.To], r)
+		}
+	}
+	// latest 返回 list 中日期不晚于 day 的最后一条汇率
+	latest := func(list []FxRate, day string) (*big.Rat, bool) {
+		i := sort.Search(len(list), func(i int) bool { return list[i].Date > day })
+		if i == 0 {
+			return nil, false
+		}
+		rate, ok := new(big.Rat).SetString(list[i-1].Rate)
+		return rate, ok
+	}
+
+	f := &fxRates{target: target, rates: map[string]*big.Rat{}}
+	for _, r := range rows {
+		currency := strings.ToUpper(r.Currency)
+		key := currency + "/" + r.Day
+		if target == "" || currency == "" || currency == target || f.rates[key] != nil {
+			continue
+		}
+		if rate, ok := latest(forward[currency], r.Day); ok {
+			f.rates[key] = rate
+		} else if rate, ok := latest(reverse[currency], r.Day); ok && rate.Sign() != 0 {
+			f.rates[key] = rate.Inv(rate)
+		}
+	}
+	return f
+}
+
+// convert 按汇率换算金额，四舍五入到分
+func (m Money) convert(rate *big.Rat) Money {
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(m)), rate)
+	// 四舍五入：|v| + 1/2 后取整
+	half := big.NewRat(1, 2)
+	abs := new(big.Rat).Abs(v)
+	abs.Add(abs, half)
+	n := new(big.Int).Quo(abs.Num(), abs.Denom())
+	if v.Sign() < 0 {
+		n.Neg(n)
+	}
+	return Money(n.Int64())
+}
+
+// ErrMixedCurrency 汇总的金额包含多个币种且没有要求换算
+var ErrMixedCurrency = errors.New("金额包含多个币种")
+
+// currencyAmount 某币种某一天的金额合计
+type currencyAmount struct {
+	Currency string
+	Day      string
+	Amount   Money
+}
+
+// sumByCurrency 按币种和日期汇总 query 选出的金额
+func sumByCurrency(query *gorm.DB, amountColumn string, currencyColumn string, dayExpr string) ([]currencyAmount, error) {
+	var rows []currencyAmount
+	err := query.
+		Select(fmt.Sprintf("%s AS currency, %s AS day, SUM(%s) AS amount", currencyColumn, dayExpr, amountColumn)).
+		Group(currencyColumn + ", " + dayExpr).
+		Scan(&rows).Error
+	return rows, err
+}
+
+// foldAmounts 合计各币种的金额，返回合计及其币种。target 为空时所有金额必须是同一币种，
+// 否则返回 ErrMixedCurrency；target 不为空时按各自日期的汇率换算为 target 后合计
+func foldAmounts(rows []currencyAmount, target string) (Money, string, error) {
+	rates, err := loadFxRates(rows, target)
+	if err != nil {
+		return 0, "", err
+	}
+	return rates.fold(rows)
+}
+
+// fold 与 foldAmounts 相同，汇率从预先加载的 f 中取，rows 需要的汇率须已包含在 loadFxRates 的参数中
+func (f *fxRates) fold(rows []currencyAmount) (Money, string, error) {
+	target := f.target
+	var total Money
+	if target == "" {
+		currencies := map[string]bool{}
+		for _, r := range rows {
+			currencies[strings.ToUpper(r.Currency)] = true
+			total += r.Amount
+		}
+		if len(currencies) > 1 {
+			names := make([]string, 0, len(currencies))
+			for c := range currencies {
+				names = append(names, c)
+			}
+			sort.Strings(names)
+			return 0, "", fmt.Errorf("%w（%s），请指定换算币种", ErrMixedCurrency, strings.Join(names, ", "))
+		}
+		for c := range currencies {
+			return total, c, nil
+		}
+		return 0, "", nil
+	}
+
+	for _, r := range rows {
+		currency := strings.ToUpper(r.Currency)
+		if currency == target {
+			total += r.Amount
+			continue
+		}
+		if currency == "" {
+			return 0, "", fmt.Errorf("%s 有金额没有币种，无法换算为 %s", r.Day, target)
+		}
+		rate, ok := f.rates[currency+"/"+r.Day]
+		if !ok {
+			return 0, "", fmt.Errorf("缺少 %s → %s 在 %s 或之前的汇率", currency, target, r.Day)
+		}
+		total += r.Amount.convert(rate)
+	}
+	return total, target, nil
+}
+
+// ReportCurrency 解析汇总接口的换算参数：convert 为真时换算为 currency，currency 为空时为配置的本位币；
+// 不换算时返回空字符串
+func ReportCurrency(convert bool, currency string) string {
+	if !convert && currency == "" {
+		return ""
+	}
+	if currency == "" {
+		return utils.BaseCurrency
+	}
+	return strings.ToUpper(currency)
+}
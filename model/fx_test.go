@@ -0,0 +1,143 @@
+package model
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		m    Money
+		rate string
+		want Money
+	}{
+		{10000, "7.1", 71000},
+		{100, "0.125", 13}, // 12.5 分四舍五入
+		{-100, "0.125", -13},
+		{100, "0.124", 12},
+		{1, "1/3", 0},
+		{2, "1/3", 1},
+		{0, "7.2", 0},
+	}
+	for _, tt := range tests {
+		rate, _ := new(big.Rat).SetString(tt.rate)
+		if got := tt.m.convert(rate); got != tt.want {
+			t.Errorf("%v.convert(%s) = %v, want %v", tt.m, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestFoldAmounts(t *testing.T) {
+	tests := []struct {
+		name         string
+		rows         []currencyAmount
+		target       string
+		want         Money
+		wantCurrency string
+		wantMixed    bool
+		wantErr      bool
+	}{
+		{name: "没有金额", want: 0, wantCurrency: ""},
+		{
+			name:         "同一币种直接合计，币种不区分大小写",
+			rows:         []currencyAmount{{"usd", "2024-04-01", 150}, {"USD", "2024-04-02", -50}},
+			want:         100,
+			wantCurrency: "USD",
+		},
+		{
+			name:      "不换算时拒绝混合币种",
+			rows:      []currencyAmount{{"USD", "2024-04-01", 150}, {"CNY", "2024-04-01", 700}},
+			wantMixed: true,
+		},
+		{
+			name:         "与目标币种相同的金额不需要汇率",
+			rows:         []currencyAmount{{"usd", "2024-04-01", 150}, {"USD", "2024-04-02", 50}},
+			target:       "usd",
+			want:         200,
+			wantCurrency: "USD",
+		},
+		{
+			name:    "没有币种的金额无法换算",
+			rows:    []currencyAmount{{"", "2024-04-01", 150}},
+			target:  "USD",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, currency, err := foldAmounts(tt.rows, tt.target)
+			switch {
+			case tt.wantMixed:
+				if !errors.Is(err, ErrMixedCurrency) {
+					t.Fatalf("err = %v, want ErrMixedCurrency", err)
+				}
+			case tt.wantErr:
+				if err == nil {
+					t.Fatal("want error")
+				}
+			case err != nil:
+				t.Fatal(err)
+			case got != tt.want || currency != tt.wantCurrency:
+				t.Errorf("foldAmounts() = %v %s, want %v %s", got, currency, tt.want, tt.wantCurrency)
+			}
+		})
+	}
+}
+
+func TestNewFxRates(t *testing.T) {
+	stored := []FxRate{
+		{Date: "2024-03-01", From: "CNY", To: "USD", Rate: "0.14"},
+		{Date: "2024-04-01", From: "USD", To: "EUR", Rate: "0.8"},
+		{Date: "2024-04-01", From: "USD", To: "CNY", Rate: "7.2"},
+		{Date: "2024-04-02", From: "CNY", To: "USD", Rate: "0.125"},
+	}
+	rows := []currencyAmount{
+		{"CNY", "2024-03-15", 1000}, // 当天没有，取之前最近一天
+		{"cny", "2024-04-02", 1000}, // 正向汇率优先于反向汇率
+		{"EUR", "2024-04-05", 1000}, // 只有反向汇率时取倒数
+		{"EUR", "2024-03-31", 1000}, // 当天及之前都没有
+		{"USD", "2024-04-01", 1000},
+	}
+	rates := newFxRates(rows, "usd", stored)
+	want := map[string]string{"CNY/2024-03-15": "7/50", "CNY/2024-04-02": "1/8", "EUR/2024-04-05": "5/4"}
+	if len(rates.rates) != len(want) {
+		t.Errorf("rates = %v, want %v", rates.rates, want)
+	}
+	for key, rate := range want {
+		if got, ok := rates.rates[key]; !ok || got.RatString() != rate {
+			t.Errorf("rate %s = %v, want %s", key, got, rate)
+		}
+	}
+
+	if _, _, err := rates.fold(rows); err == nil {
+		t.Error("fold() without the EUR rate on 2024-03-31: want error")
+	}
+	total, currency, err := rates.fold(rows[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 140+125+1250 || currency != "USD" {
+		t.Errorf("fold() = %v %s, want 15.15 USD", total, currency)
+	}
+}
+
+func TestFxRateNormalize(t *testing.T) {
+	r := FxRate{Date: "04/01/2024", From: " cny", To: "usd ", Rate: "0.1385"}
+	if err := r.normalize(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Date != "2024-04-01" || r.From != "CNY" || r.To != "USD" || r.Rate != "0.1385000000" {
+		t.Errorf("normalize() = %+v", r)
+	}
+	for _, bad := range []FxRate{
+		{Date: "2024-04-01", From: "USD", To: "USD", Rate: "1"},
+		{Date: "2024-04-01", From: "USD", To: "CNY", Rate: "0"},
+		{Date: "2024-04-01", From: "USD", To: "CNY", Rate: "abc"},
+		{Date: "April 1", From: "USD", To: "CNY", Rate: "7.1"},
+	} {
+		if err := bad.normalize(); err == nil {
+			t.Errorf("normalize(%+v) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package model
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransactionChange 重新导入时交易字段的修改历史，回滚批次时按旧值恢复
+type TransactionChange struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TransactionID string    `gorm:"type:varchar(50);index" json:"transaction_id"`
+	BatchID       uint      `gorm:"index" json:"batch_id"` // 做出修改的导入批次
+	Column        string    `gorm:"type:varchar(50)" json:"column"`
+	OldValue      string    `gorm:"type:varchar(255)" json:"old_value"`
+	NewValue      string    `gorm:"type:varchar(255)" json:"new_value"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// mutableField 供应商重新导出同一笔交易时可能变化的字段
+type mutableField struct {
+	Field  string // Transaction 的字段名，与 ParsedTransaction.Blank 对应
+	Column string
+	Status bool // 是否为状态字段，变化时计入 StatusChangedRows
+	Value  func(t Transaction) interface{}
+}
+
+// mutableFields 重新导入时以新值为准的字段，其余字段保持第一次导入时的值
+var mutableFields = []mutableField{
+	{Field: "TransactionStatus", Column: "transaction_status", Status: true, Value: func(t Transaction) interface{} { return t.TransactionStatus }},
+	{Field: "SettlementStatus", Column: "settlement_status", Status: true, Value: func(t Transaction) interface{} { return t.SettlementStatus }},
+	{Field: "TransactionAmount", Column: "transaction_amount", Value: func(t Transaction) interface{} { return t.TransactionAmount }},
+	{Field: "TransactionFee", Column: "transaction_fee", Value: func(t Transaction) interface{} { return t.TransactionFee }},
+	{Field: "AuthorizationCode", Column: "authorization_code", Value: func(t Transaction) interface{} { return t.AuthorizationCode }},
+	{Field: "ResultCode", Column: "result_code", Value: func(t Transaction) interface{} { return t.ResultCode }},
+	{Field: "ResultDescription", Column: "result_description", Value: func(t Transaction) interface{} { return t.ResultDescription }},
+}
+
+// mutableColumns 查询已有交易时需要取出的列
+func mutableColumns() []string {
+	columns := []string{"transaction_id"}
+	for _, f := range mutableFields {
+		columns = append(columns, f.Column)
+	}
+	return columns
+}
+
+// transactionChanges 比较已有交易与新导出的同一笔交易，返回变化的字段。
+// 新文件没有提供的字段（见 ParsedTransaction.Blank）不覆盖已有的值，改为0或空的值照常更新
+func transactionChanges(old Transaction, updated ParsedTransaction) (changes []TransactionChange, statusChanged bool) {
+	for _, f := range mutableFields {
+		if updated.Blank[f.Field] {
+			continue
+		}
+		oldValue, newValue := fmt.Sprint(f.Value(old)), fmt.Sprint(f.Value(updated.Transaction))
+		if newValue == oldValue {
+			continue
+		}
+		changes = append(changes, TransactionChange{
+			TransactionID: old.TransactionID,
+			Column:        f.Column,
+			OldValue:      oldValue,
+			NewValue:      newValue,
+		})
+		statusChanged = statusChanged || f.Status
+	}
+	return changes, statusChanged
+}
+
+// updateTransaction 把变化的字段写入交易并记录修改历史
+func updateTransaction(tx *gorm.DB, batchID uint, changes []TransactionChange) error {
+	updates := make(map[string]interface{}, len(changes))
+	for i := range changes {
+		changes[i].BatchID = batchID
+		updates[changes[i].Column] = changes[i].NewValue
+	}
+	if err := tx.Model(&Transaction{}).Where("transaction_id = ?", changes[0].TransactionID).
+		Updates(updates).Error; err != nil {
+		return err
+	}
+	return tx.Create(&changes).Error
+}
+
+// restoreTransactionChanges 回滚批次时按相反顺序恢复它修改过的交易字段，修改历史保留，可由批次的 RolledBack 判断已撤销。
+// 同一字段之后又被未回滚的批次修改过，或当前值已不是该批次写入的值时拒绝回滚，避免用旧值覆盖较新的数据
+func restoreTransactionChanges(tx *gorm.DB, batchID uint) error {
+	var changes []TransactionChange
+	if err := tx.Where("batch_id = ?", batchID).Order("id DESC").Find(&changes).Error; err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(changes))
+	for _, change := range changes {
+		ids = append(ids, change.TransactionID)
+	}
+	var later []TransactionChange
+	if err := tx.Where("transaction_id IN (?) AND id > ? AND batch_id <> ?",
+		tx.Model(&TransactionChange{}).Select("transaction_id").Where("batch_id = ?", batchID), changes[len(changes)-1].ID, batchID).
+		Where("batch_id NOT IN (?)", tx.Model(&ImportBatch{}).Select("id").Where("rolled_back = ?", true)).
+		Find(&later).Error; err != nil {
+		return err
+	}
+	latest := make(map[string]TransactionChange, len(later))
+	for _, l := range later {
+		key := l.TransactionID + "|" + l.Column
+		if l.ID > latest[key].ID {
+			latest[key] = l
+		}
+	}
+	for _, change := range changes {
+		if l, ok := latest[change.TransactionID+"|"+change.Column]; ok && l.ID > change.ID {
+			return fmt.Errorf("交易 %s 的 %s 之后又被批次 %d 修改，请先回滚批次 %d", change.TransactionID, change.Column, l.BatchID, l.BatchID)
+		}
+	}
+
+	current, err := existingTransactions(tx, ids)
+	if err != nil {
+		return err
+	}
+	// 当前值，同一批次多次修改同一字段时按恢复的顺序更新
+	values := map[string]string{}
+	for id, t := range current {
+		for _, f := range mutableFields {
+			values[id+"|"+f.Column] = fmt.Sprint(f.Value(t))
+		}
+	}
+	for _, change := range changes {
+		key := change.TransactionID + "|" + change.Column
+		if value, ok := values[key]; ok && value != change.NewValue {
+			return fmt.Errorf("交易 %s 的 %s 当前为 %q，不是批次 %d 写入的 %q，无法回滚", change.TransactionID, change.Column, value, batchID, change.NewValue)
+		}
+		values[key] = change.OldValue
+		if err := tx.Model(&Transaction{}).Where("transaction_id = ?", change.TransactionID).
+			Update(change.Column, change.OldValue).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTransactionChanges 查询一笔交易的修改历史，按时间先后排列
+func GetTransactionChanges(transactionID string) ([]TransactionChange, error) {
+	var changes []TransactionChange
+	err := db.Where("transaction_id = ?", transactionID).Order("id ASC").Find(&changes).Error
+	return changes, err
+}
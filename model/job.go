@@ -0,0 +1,229 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 导入任务状态
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// 默认的供应商/平台，同一供应商的导入任务串行执行
+const (
+	DefaultCardProvider    = "vcc"
+	DefaultBillingPlatform = "facebook"
+)
+
+// ImportJob 后台导入任务。上传的文件保存在磁盘上，任务记录保存在数据库中，
+// 服务重启后未完成的任务会重新排队
+type ImportJob struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Kind       string     `gorm:"type:varchar(20)" json:"kind"`
+	Provider   string     `gorm:"type:varchar(50)" json:"provider"`
+	FilePath   string     `gorm:"type:varchar(500)" json:"-"`
+	FileName   string     `gorm:"type:varchar(255)" json:"file_name"`
+	Uploader   string     `gorm:"type:varchar(100)" json:"uploader"`
+	BestEffort bool       `gorm:"type:boolean" json:"best_effort"`
+	Status     string     `gorm:"type:varchar(20);index" json:"status"`
+	Processed  int        `gorm:"type:int" json:"processed"`
+	Total      int        `gorm:"type:int" json:"total"`
+	BatchID    uint       `json:"batch_id"`
+	Error      string     `gorm:"type:varchar(1000)" json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+// CardOpener 按供应商打开虚拟卡文件，由 importer 包提供，model 不直接依赖各供应商的解析器
+type CardOpener func(provider string, filePath string) (TransactionReader, error)
+
+// BillingReader 按广告平台解析账单文件，由 importer 包提供
+type BillingReader func(platform string, filePath string) (*BillingFile, error)
+
+var (
+	jobMu       sync.Mutex
+	jobRunning  = map[string]bool{} // 正在执行导入的供应商
+	jobWake     = make(chan struct{}, 1)
+	openCard    CardOpener
+	readBilling BillingReader
+)
+
+// jobKey 串行化的粒度：同一类文件的同一供应商
+func (j ImportJob) jobKey() string {
+	return j.Kind + "/" + j.Provider
+}
+
+// EnqueueImportJob 登记一个导入任务并唤醒后台 worker
+func EnqueueImportJob(kind string, provider string, filePath string, opts ImportOptions) (*ImportJob, error) {
+	job := &ImportJob{
+		Kind:       kind,
+		Provider:   provider,
+		FilePath:   filePath,
+		FileName:   opts.FileName,
+		Uploader:   opts.Uploader,
+		BestEffort: opts.BestEffort,
+		Status:     JobQueued,
+	}
+	if err := db.Create(job).Error; err != nil {
+		return nil, err
+	}
+	wakeImportWorker()
+	return job, nil
+}
+
+func wakeImportWorker() {
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// StartImportWorker 启动后台导入 worker，虚拟卡文件用 open 按供应商打开，账单文件用 read 按平台解析。
+// 上次退出时仍在执行的任务重新排队，其未完成的批次标记为取消
+func StartImportWorker(open CardOpener, read BillingReader) {
+	openCard, readBilling = open, read
+
+	if err := cancelInterruptedBatches(); err != nil {
+		log.Printf("Failed to cancel interrupted import batches: %v\n", err)
+	}
+	if err := db.Model(&ImportJob{}).Where("status = ?", JobRunning).
+		Updates(map[string]interface{}{"status": JobQueued, "processed": 0}).Error; err != nil {
+		log.Printf("Failed to requeue import jobs: %v\n", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			dispatchImportJobs()
+			select {
+			case <-jobWake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// dispatchImportJobs 按登记顺序启动排队中的任务，同一供应商同时只运行一个
+func dispatchImportJobs() {
+	var jobs []ImportJob
+	if err := db.Where("status = ?", JobQueued).Order("id ASC").Find(&jobs).Error; err != nil {
+		log.Printf("Failed to load import jobs: %v\n", err)
+		return
+	}
+
+	jobMu.Lock()
+	defer jobMu.Unlock()
+	for _, job := range jobs {
+		if jobRunning[job.jobKey()] {
+			continue
+		}
+		now := time.Now()
+		result := db.Model(&ImportJob{}).Where("id = ? AND status = ?", job.ID, JobQueued).
+			Updates(map[string]interface{}{"status": JobRunning, "started_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+		jobRunning[job.jobKey()] = true
+		go runImportJob(job)
+	}
+}
+
+// runImportJob 执行一个导入任务并记录结果，结束后删除上传的文件
+func runImportJob(job ImportJob) {
+	defer func() {
+		jobMu.Lock()
+		delete(jobRunning, job.jobKey())
+		jobMu.Unlock()
+		wakeImportWorker()
+	}()
+
+	updates := map[string]interface{}{}
+	batch, err := job.run()
+	if batch != nil {
+		updates["batch_id"] = batch.ID
+		updates["total"] = batch.TotalRows
+		updates["processed"] = batch.TotalRows
+	}
+	if err != nil {
+		updates["status"] = JobFailed
+		updates["error"] = err.Error()
+	} else {
+		updates["status"] = JobDone
+	}
+	updates["finished_at"] = time.Now()
+	if err := db.Model(&ImportJob{}).Where("id = ?", job.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to update import job %d: %v\n", job.ID, err)
+	}
+	_ = os.Remove(job.FilePath)
+}
+
+// run 调用对应的导入函数，进度写回任务记录
+func (j ImportJob) run() (batch *ImportBatch, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("导入异常: %v", r)
+		}
+	}()
+
+	opts := ImportOptions{
+		FileName:   j.FileName,
+		Uploader:   j.Uploader,
+		Provider:   j.Provider,
+		BestEffort: j.BestEffort,
+		Progress: func(processed int, total int) {
+			db.Model(&ImportJob{}).Where("id = ?", j.ID).
+				Updates(map[string]interface{}{"processed": processed, "total": total})
+		},
+	}
+	switch j.Kind {
+	case ImportKindCard:
+		reader, err := openCard(j.Provider, j.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ImportTransactions(reader, j.FilePath, opts)
+	case ImportKindBilling:
+		billing, err := readBilling(j.Provider, j.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		return ImportTransactionRecords(billing, j.FilePath, opts)
+	default:
+		return nil, fmt.Errorf("未知的导入类型: %s", j.Kind)
+	}
+}
+
+// GetImportJob 查询导入任务
+func GetImportJob(id uint) (ImportJob, error) {
+	var job ImportJob
+	err := db.First(&job, id).Error
+	return job, err
+}
+
+// GetImportJobs 分页查询导入任务，status 为空时查询全部
+func GetImportJobs(status string, pageSize int, pageNum int) ([]ImportJob, int64, error) {
+	var jobs []ImportJob
+	var total int64
+	query := db.Model(&ImportJob{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id DESC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Find(&jobs).Error
+	return jobs, total, err
+}
@@ -0,0 +1,431 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 交易类型对卡余额的影响
+const (
+	EffectSigned   = "signed"   // 余额加上带符号的订单金额，方向沿用导出文件中的符号
+	EffectAdd      = "add"      // 余额增加订单金额的绝对值
+	EffectSubtract = "subtract" // 余额减少订单金额的绝对值
+	EffectIgnore   = "ignore"   // 不影响余额
+)
+
+// LedgerRule 余额规则：某种交易类型如何影响卡余额。
+// 供应商导出的金额带符号且各家习惯不同：signed 沿用文件中的符号，add、subtract 按绝对值计算，方向只由 Effect 决定
+type LedgerRule struct {
+	TransactionType string    `gorm:"primaryKey;type:varchar(50)" json:"transaction_type"`
+	Effect          string    `gorm:"type:varchar(10);not null" json:"effect"`
+	ApplyFee        bool      `json:"apply_fee"` // 是否另外扣除交易手续费
+	UpdatedBy       string    `gorm:"type:varchar(100)" json:"updated_by"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// defaultLedgerRules 初始规则，与原来写死在 CalVccBalance 中的公式一致：
+// 余额 = 开卡 + 充值、交易退款、交易授权、卡充退、交易授权撤销带符号的订单金额之和，不计手续费。
+// 供应商导出的交易授权、卡充退为负数时即 开卡 + 充值 + 交易退款 + 交易授权撤销 - 交易授权 - 卡充退
+var defaultLedgerRules = []LedgerRule{
+	{TransactionType: TypeOpenCard, Effect: EffectSigned},
+	{TransactionType: TypeTopUp, Effect: EffectSigned},
+	{TransactionType: TypeRefund, Effect: EffectSigned},
+	{TransactionType: TypeAuthReversal, Effect: EffectSigned},
+	{TransactionType: TypeAuthorization, Effect: EffectSigned},
+	{TransactionType: TypeTopUpRefund, Effect: EffectSigned},
+	{TransactionType: TypeSettlement, Effect: EffectIgnore},
+}
+
+// seedLedgerRules 补充缺少的默认规则；没有被人修改过的规则更新为当前的默认值，已修改过的规则保持不变
+func seedLedgerRules() error {
+	for _, rule := range defaultLedgerRules {
+		rule.UpdatedBy = "system"
+		var existing LedgerRule
+		if err := db.Where(LedgerRule{TransactionType: rule.TransactionType}).
+			Attrs(rule).FirstOrCreate(&existing).Error; err != nil {
+			return err
+		}
+		if existing.UpdatedBy == "system" && (existing.Effect != rule.Effect || existing.ApplyFee != rule.ApplyFee) {
+			if err := db.Save(&rule).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetLedgerRules 查询所有余额规则
+func GetLedgerRules() ([]LedgerRule, error) {
+	var rules []LedgerRule
+	err := db.Order("transaction_type ASC").Find(&rules).Error
+	return rules, err
+}
+
+// SaveLedgerRule 新增或修改一种交易类型的余额规则
+func SaveLedgerRule(rule LedgerRule, user string) (*LedgerRule, error) {
+	rule.TransactionType = strings.TrimSpace(rule.TransactionType)
+	rule.Effect = strings.ToLower(strings.TrimSpace(rule.Effect))
+	if rule.TransactionType == "" {
+		return nil, errors.New("交易类型不能为空")
+	}
+	switch rule.Effect {
+	case EffectSigned, EffectAdd, EffectSubtract, EffectIgnore:
+	default:
+		return nil, fmt.Errorf("无效的余额影响 %q，可选 %s、%s、%s、%s", rule.Effect, EffectSigned, EffectAdd, EffectSubtract, EffectIgnore)
+	}
+	rule.UpdatedBy = user
+	if err := db.Save(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// ledgerRules 以交易类型为键的余额规则，没有规则的交易类型不影响余额
+func ledgerRules() (map[string]LedgerRule, error) {
+	rules, err := GetLedgerRules()
+	if err != nil {
+		return nil, err
+	}
+	byType := make(map[string]LedgerRule, len(rules))
+	for _, r := range rules {
+		byType[r.TransactionType] = r
+	}
+	return byType, nil
+}
+
+// apply 按规则计算一组交易对余额的影响，amount、fee 为绝对值之和，signed 为带符号的订单金额之和
+func (r LedgerRule) apply(amount Money, signed Money, fee Money) Money {
+	var effect Money
+	switch r.Effect {
+	case EffectSigned:
+		effect = signed
+	case EffectAdd:
+		effect = amount
+	case EffectSubtract:
+		effect = -amount
+	}
+	if r.ApplyFee {
+		effect -= fee
+	}
+	return effect
+}
+
+// LedgerLine 余额明细中的一行：某交易类型、某币种的交易合计及其按规则对余额的影响
+type LedgerLine struct {
+	TransactionType string `json:"transaction_type"`
+	Effect          string `json:"effect"`
+	ApplyFee        bool   `json:"apply_fee"`
+	Currency        string `json:"currency"`
+	Count           int64  `json:"count"`
+	Amount          Money  `json:"amount"`        // 订单金额绝对值之和
+	SignedAmount    Money  `json:"signed_amount"` // 带符号的订单金额之和
+	Fee             Money  `json:"fee"`           // 手续费绝对值之和
+	Contribution    Money  `json:"contribution"`  // 对余额的影响，币种为 BalanceBreakdown.Currency
+}
+
+// BalanceBreakdown 卡余额及其按余额规则的推导过程
+type BalanceBreakdown struct {
+	Fb_id      string       `json:"fb_id"`
+	CardNumber string       `json:"card_number"`
+	Balance    Money        `json:"balance"`
+	Currency   string       `json:"currency"`
+	Lines      []LedgerLine `json:"lines"`
+}
+
+// ledgerRow 按交易类型、币种和日期汇总的交易，日期用于换算
+type ledgerRow struct {
+	TransactionType string
+	Currency        string
+	Day             string
+	Count           int64
+	Amount          Money
+	Signed          Money
+	Fee             Money
+}
+
+// VccBalanceBreakdown 按余额规则计算卡余额并给出每条规则的贡献。
+// 开卡交易作为起始金额，不受时间范围限制；手续费按订单币种计算。loc、convert 见 CalVccBalance
+func VccBalanceBreakdown(fb_id string, cardnumber string, startTime int, endTime int, loc *time.Location, convert string) (*BalanceBreakdown, error) {
+	// 查找与特定卡号相关的开卡交易以获取初始金额
+	var initTrans Transaction
+	if err := db.Where("card_number = ? AND transaction_type = ? and nickname = ?", cardnumber, TypeOpenCard, fb_id).First(&initTrans).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errNoOpenCard(cardnumber)
+		}
+		return nil, err
+	}
+	rules, err := ledgerRules()
+	if err != nil {
+		return nil, err
+	}
+
+	types := ledgerTypes(rules)
+	var rows []ledgerRow
+	if len(types) > 0 {
+		query := db.Table("transaction").
+			Where("card_number = ? AND nickname = ? AND transaction_type IN ?", cardnumber, fb_id, types)
+		err = whereTransactionTime(query, startTime, endTime, loc).
+			Select("transaction_type, order_currency AS currency, " + transactionDay + " AS day, COUNT(*) AS count, " +
+				"SUM(ABS(order_amount)) AS amount, SUM(order_amount) AS signed, SUM(ABS(transaction_fee)) AS fee").
+			Group("transaction_type, order_currency, " + transactionDay).
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	rows = append(rows, ledgerRow{
+		TransactionType: TypeOpenCard,
+		Currency:        initTrans.OrderCurrency,
+		Day:             initTrans.TransactionTime.Format(DateLayout),
+		Count:           1,
+		Amount:          initTrans.OrderAmount.Abs(),
+		Signed:          initTrans.OrderAmount,
+		Fee:             initTrans.TransactionFee.Abs(),
+	})
+
+	breakdown := &BalanceBreakdown{Fb_id: fb_id, CardNumber: cardnumber}
+	breakdown.Balance, breakdown.Currency, breakdown.Lines, err = foldLedger(rows, rules, convert)
+	if err != nil {
+		return nil, err
+	}
+	return breakdown, nil
+}
+
+// errNoOpenCard 卡没有开卡交易，无法确定起始金额
+func errNoOpenCard(cardnumber string) error {
+	return fmt.Errorf("没有找到与卡号 %s 相关的开卡交易", cardnumber)
+}
+
+// ledgerTypes 影响余额的交易类型，开卡交易单独作为起始金额，不在其中
+func ledgerTypes(rules map[string]LedgerRule) []string {
+	var types []string
+	for t, r := range rules {
+		if t != TypeOpenCard && (r.Effect != EffectIgnore || r.ApplyFee) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// foldLedger 按余额规则把汇总的交易归并为明细行，各行的贡献分别换算后合计为余额
+func foldLedger(rows []ledgerRow, rules map[string]LedgerRule, convert string) (Money, string, []LedgerLine, error) {
+	rates, err := loadFxRates(ledgerDays(rows), convert)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	return rates.foldLedger(rows, rules)
+}
+
+// ledgerDays 汇总的交易涉及的币种和日期，用于预先加载汇率
+func ledgerDays(rows []ledgerRow) []currencyAmount {
+	days := make([]currencyAmount, 0, len(rows))
+	for _, row := range rows {
+		days = append(days, currencyAmount{Currency: row.Currency, Day: row.Day})
+	}
+	return days
+}
+
+// foldLedger 同函数 foldLedger，汇率从预先加载的 f 中取
+func (f *fxRates) foldLedger(rows []ledgerRow, rules map[string]LedgerRule) (Money, string, []LedgerLine, error) {
+	type lineKey struct{ Type, Currency string }
+	lines := map[lineKey]*LedgerLine{}
+	effects := map[lineKey][]currencyAmount{}
+	var all []currencyAmount
+	for _, row := range rows {
+		rule := rules[row.TransactionType]
+		if rule.Effect == "" {
+			rule.Effect = EffectIgnore
+		}
+		key := lineKey{row.TransactionType, strings.ToUpper(row.Currency)}
+		line, ok := lines[key]
+		if !ok {
+			line = &LedgerLine{TransactionType: row.TransactionType, Effect: rule.Effect, ApplyFee: rule.ApplyFee, Currency: key.Currency}
+			lines[key] = line
+		}
+		line.Count += row.Count
+		line.Amount += row.Amount
+		line.SignedAmount += row.Signed
+		line.Fee += row.Fee
+		effect := currencyAmount{Currency: row.Currency, Day: row.Day, Amount: rule.apply(row.Amount, row.Signed, row.Fee)}
+		effects[key] = append(effects[key], effect)
+		all = append(all, effect)
+	}
+
+	balance, currency, err := f.fold(all)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	result := make([]LedgerLine, 0, len(lines))
+	for key, line := range lines {
+		if line.Contribution, _, err = f.fold(effects[key]); err != nil {
+			return 0, "", nil, err
+		}
+		result = append(result, *line)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.TransactionType != b.TransactionType {
+			return a.TransactionType < b.TransactionType
+		}
+		return a.Currency < b.Currency
+	})
+	return balance, currency, result, nil
+}
+
+// vccTotals 一张卡的余额、总消耗及余额按交易类别的构成
+type vccTotals struct {
+	Opening  Money
+	TopUps   Money
+	Spend    Money
+	Refunds  Money
+	Other    Money
+	Balance  Money
+	Deplete  Money
+	Currency string
+	Error    string // 无法计算的原因，此时对应的金额为 0
+}
+
+// add 把一条明细行的贡献计入所属的类别
+func (t *vccTotals) add(line LedgerLine) {
+	switch line.TransactionType {
+	case TypeOpenCard:
+		t.Opening += line.Contribution
+	case TypeTopUp, TypeTopUpRefund:
+		t.TopUps += line.Contribution
+	case TypeAuthorization:
+		t.Spend += line.Contribution
+	case TypeRefund, TypeAuthReversal:
+		t.Refunds += line.Contribution
+	default:
+		t.Other += line.Contribution
+	}
+}
+
+// cardLedgerRow 按卡号、交易类型、币种和日期汇总的交易，Signed 为带符号的订单金额之和，用于计算总消耗
+type cardLedgerRow struct {
+	CardNumber      string
+	TransactionType string
+	Currency        string
+	Day             string
+	Count           int64
+	Amount          Money
+	Fee             Money
+	Signed          Money
+}
+
+// vccTotalsByCard 一次汇总广告账户下多张卡的余额和总消耗，结果与逐张调用 CalVccBalance、
+// CalVccTotalDeplete 相同。开卡交易、其余交易和汇率各一次查询，不随卡的数量增加；
+// 单张卡无法计算（没有开卡交易、币种混合、缺少汇率）时记在 Error 中，查询失败时返回错误
+func vccTotalsByCard(fb_id string, cards []string, startTime int, endTime int, loc *time.Location, convert string) (map[string]*vccTotals, error) {
+	totals := make(map[string]*vccTotals, len(cards))
+	for _, card := range cards {
+		totals[card] = &vccTotals{}
+	}
+	if len(cards) == 0 {
+		return totals, nil
+	}
+	rules, err := ledgerRules()
+	if err != nil {
+		return nil, err
+	}
+
+	// 与 VccBalanceBreakdown 的 First 一致，每张卡取主键最小的开卡交易
+	var opens []Transaction
+	if err := db.Select("transaction_id, card_number, transaction_time, order_amount, order_currency, transaction_fee").
+		Where("nickname = ? AND card_number IN ? AND transaction_type = ?", fb_id, cards, TypeOpenCard).
+		Order("transaction_id ASC").Find(&opens).Error; err != nil {
+		return nil, err
+	}
+	openByCard := make(map[string]Transaction, len(opens))
+	for _, t := range opens {
+		if _, ok := openByCard[t.CardNumber]; !ok {
+			openByCard[t.CardNumber] = t
+		}
+	}
+
+	// 交易授权即使不影响余额也要查出，用于计算总消耗
+	types := ledgerTypes(rules)
+	inLedger := make(map[string]bool, len(types))
+	for _, t := range types {
+		inLedger[t] = true
+	}
+	queryTypes := types
+	if !inLedger[TypeAuthorization] {
+		queryTypes = append(queryTypes, TypeAuthorization)
+	}
+	var rows []cardLedgerRow
+	query := db.Table("transaction").
+		Where("nickname = ? AND card_number IN ? AND transaction_type IN ?", fb_id, cards, queryTypes)
+	err = whereTransactionTime(query, startTime, endTime, loc).
+		Select("card_number, transaction_type, order_currency AS currency, " + transactionDay + " AS day, COUNT(*) AS count, " +
+			"SUM(ABS(order_amount)) AS amount, SUM(ABS(transaction_fee)) AS fee, SUM(order_amount) AS signed").
+		Group("card_number, transaction_type, order_currency, " + transactionDay).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	ledger := map[string][]ledgerRow{}
+	depletes := map[string][]currencyAmount{}
+	days := make([]currencyAmount, 0, len(rows)+len(opens))
+	for _, r := range rows {
+		days = append(days, currencyAmount{Currency: r.Currency, Day: r.Day})
+		if inLedger[r.TransactionType] {
+			ledger[r.CardNumber] = append(ledger[r.CardNumber], ledgerRow{
+				TransactionType: r.TransactionType, Currency: r.Currency, Day: r.Day, Count: r.Count, Amount: r.Amount, Signed: r.Signed, Fee: r.Fee,
+			})
+		}
+		if r.TransactionType == TypeAuthorization {
+			depletes[r.CardNumber] = append(depletes[r.CardNumber], currencyAmount{Currency: r.Currency, Day: r.Day, Amount: r.Signed})
+		}
+	}
+
+	// 所有卡需要的汇率一次加载
+	for _, open := range openByCard {
+		days = append(days, currencyAmount{Currency: open.OrderCurrency, Day: open.TransactionTime.Format(DateLayout)})
+	}
+	rates, err := loadFxRates(days, convert)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, card := range cards {
+		t := totals[card]
+		deplete, depleteCurrency, depleteErr := rates.fold(depletes[card])
+		t.Deplete = deplete
+
+		if open, ok := openByCard[card]; !ok {
+			t.Error = errNoOpenCard(card).Error()
+		} else {
+			cardRows := append(ledger[card], ledgerRow{
+				TransactionType: TypeOpenCard,
+				Currency:        open.OrderCurrency,
+				Day:             open.TransactionTime.Format(DateLayout),
+				Count:           1,
+				Amount:          open.OrderAmount.Abs(),
+				Signed:          open.OrderAmount,
+				Fee:             open.TransactionFee.Abs(),
+			})
+			balance, currency, lines, err := rates.foldLedger(cardRows, rules)
+			if err != nil {
+				t.Error = err.Error()
+			} else {
+				t.Balance, t.Currency = balance, currency
+				for _, line := range lines {
+					t.add(line)
+				}
+			}
+		}
+		if depleteErr != nil && t.Error == "" {
+			t.Error = depleteErr.Error()
+		}
+		if t.Currency == "" {
+			t.Currency = depleteCurrency
+		}
+	}
+	return totals, nil
+}
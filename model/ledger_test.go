@@ -0,0 +1,178 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+// ledgerRowsOf 与 VccBalanceBreakdown 的查询一样按交易类型、币种和日期汇总交易
+func ledgerRowsOf(trans []Transaction) []ledgerRow {
+	type key struct{ Type, Currency, Day string }
+	index := map[key]int{}
+	var rows []ledgerRow
+	for _, t := range trans {
+		k := key{t.TransactionType, t.OrderCurrency, t.TransactionTime.Format(DateLayout)}
+		i, ok := index[k]
+		if !ok {
+			i = len(rows)
+			index[k] = i
+			rows = append(rows, ledgerRow{TransactionType: k.Type, Currency: k.Currency, Day: k.Day})
+		}
+		rows[i].Count++
+		rows[i].Amount += t.OrderAmount.Abs()
+		rows[i].Signed += t.OrderAmount
+		rows[i].Fee += t.TransactionFee.Abs()
+	}
+	return rows
+}
+
+// defaultRules 以交易类型为键的默认余额规则
+func defaultRules() map[string]LedgerRule {
+	rules := make(map[string]LedgerRule, len(defaultLedgerRules))
+	for _, r := range defaultLedgerRules {
+		rules[r.TransactionType] = r
+	}
+	return rules
+}
+
+func TestFoldLedgerMatchesSignedFormula(t *testing.T) {
+	day1 := time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	tx := func(typ string, at time.Time, amount Money, fee Money) Transaction {
+		return Transaction{TransactionType: typ, TransactionTime: at, OrderAmount: amount, OrderCurrency: "USD", TransactionFee: fee}
+	}
+
+	tests := []struct {
+		name  string
+		open  Transaction
+		trans []Transaction
+	}{
+		{
+			name: "常见的符号",
+			open: tx(TypeOpenCard, day1, 10000, 0),
+			trans: []Transaction{
+				tx(TypeTopUp, day1, 5000, 100),
+				tx(TypeAuthorization, day1, -3000, 20),
+				tx(TypeAuthorization, day2, -2000, 20),
+				tx(TypeRefund, day2, 1000, 0),
+				tx(TypeAuthReversal, day2, 500, 0),
+				tx(TypeTopUpRefund, day2, -1500, 0),
+				tx(TypeSettlement, day2, -3000, 0),
+			},
+		},
+		{
+			name: "供应商导出的符号与常见的相反",
+			open: tx(TypeOpenCard, day1, 10000, 0),
+			trans: []Transaction{
+				tx(TypeTopUp, day1, 5000, 0),
+				tx(TypeAuthorization, day1, 3000, 0),
+				tx(TypeRefund, day2, -1000, 0),
+				tx(TypeAuthReversal, day2, -500, 0),
+				tx(TypeTopUpRefund, day2, 1500, 0),
+			},
+		},
+		{
+			name: "同一天同一类型中符号相反的交易",
+			open: tx(TypeOpenCard, day1, 10000, 0),
+			trans: []Transaction{
+				tx(TypeAuthorization, day1, -3000, 0),
+				tx(TypeAuthorization, day1, 3000, 0),
+				tx(TypeAuthorization, day1, -1200, 0),
+				tx(TypeRefund, day1, 700, 0),
+				tx(TypeRefund, day1, -200, 0),
+			},
+		},
+		{
+			name: "开卡金额为负数",
+			open: tx(TypeOpenCard, day1, -500, 0),
+			trans: []Transaction{
+				tx(TypeTopUp, day1, 2000, 0),
+				tx(TypeAuthorization, day2, -800, 0),
+			},
+		},
+		{
+			name: "没有其他交易",
+			open: tx(TypeOpenCard, day1, 10000, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 原来写死在 CalVccBalance 中的公式：开卡 + 这些类型带符号的订单金额之和
+			want := tt.open.OrderAmount
+			for _, tr := range tt.trans {
+				switch tr.TransactionType {
+				case TypeTopUp, TypeRefund, TypeAuthorization, TypeTopUpRefund, TypeAuthReversal:
+					want += tr.OrderAmount
+				}
+			}
+
+			rows := ledgerRowsOf(append([]Transaction{tt.open}, tt.trans...))
+			balance, currency, lines, err := foldLedger(rows, defaultRules(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance != want || currency != "USD" {
+				t.Errorf("foldLedger() = %v %s, want %v USD", balance, currency, want)
+			}
+
+			var totals vccTotals
+			var sum Money
+			for _, line := range lines {
+				totals.add(line)
+				sum += line.Contribution
+			}
+			if sum != balance {
+				t.Errorf("lines contribute %v, balance %v", sum, balance)
+			}
+			if got := totals.Opening + totals.TopUps + totals.Spend + totals.Refunds + totals.Other; got != balance {
+				t.Errorf("categories sum to %v, balance %v", got, balance)
+			}
+			if totals.Opening != tt.open.OrderAmount {
+				t.Errorf("Opening = %v, want %v", totals.Opening, tt.open.OrderAmount)
+			}
+		})
+	}
+}
+
+func TestFoldLedgerRuleEffects(t *testing.T) {
+	at := time.Date(2024, 4, 1, 8, 0, 0, 0, time.UTC)
+	trans := []Transaction{
+		{TransactionType: TypeOpenCard, TransactionTime: at, OrderAmount: 10000, OrderCurrency: "USD"},
+		{TransactionType: TypeAuthorization, TransactionTime: at, OrderAmount: -3000, OrderCurrency: "USD", TransactionFee: 30},
+		{TransactionType: TypeAuthorization, TransactionTime: at, OrderAmount: 2000, OrderCurrency: "USD", TransactionFee: -20},
+		{TransactionType: "其他", TransactionTime: at, OrderAmount: 400, OrderCurrency: "USD"},
+	}
+	open := LedgerRule{TransactionType: TypeOpenCard, Effect: EffectSigned}
+
+	tests := []struct {
+		name string
+		auth LedgerRule
+		want Money
+	}{
+		{"signed 沿用文件中的符号", LedgerRule{Effect: EffectSigned}, 10000 - 3000 + 2000},
+		{"subtract 按绝对值减少", LedgerRule{Effect: EffectSubtract}, 10000 - 3000 - 2000},
+		{"add 按绝对值增加", LedgerRule{Effect: EffectAdd}, 10000 + 3000 + 2000},
+		{"subtract 另外扣除手续费", LedgerRule{Effect: EffectSubtract, ApplyFee: true}, 10000 - 5000 - 50},
+		{"ignore 只扣除手续费", LedgerRule{Effect: EffectIgnore, ApplyFee: true}, 10000 - 50},
+		{"ignore", LedgerRule{Effect: EffectIgnore}, 10000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.auth.TransactionType = TypeAuthorization
+			rules := map[string]LedgerRule{TypeOpenCard: open, TypeAuthorization: tt.auth}
+			balance, _, lines, err := foldLedger(ledgerRowsOf(trans), rules, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance != tt.want {
+				t.Errorf("balance = %v, want %v", balance, tt.want)
+			}
+			// 没有规则的交易类型列出但不影响余额
+			for _, line := range lines {
+				if line.TransactionType == "其他" && (line.Effect != EffectIgnore || line.Contribution != 0) {
+					t.Errorf("line without rule = %+v, want ignored", line)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 匹配方式
+const (
+	MatchAuto   = "auto"   // 导入或定时核对时由 Reconcile 自动匹配
+	MatchManual = "manual" // 人工指定
+)
+
+// Reconciliation 一笔账单与一笔交易清算的匹配。多笔账单合并为一笔清算时每笔账单各一条，SettlementID 相同。
+// 交易的 is_judge 和账单的 is_trading_authorization 由本表中未撤销的匹配得出，不单独修改
+type Reconciliation struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	SettlementID string     `gorm:"type:varchar(50);index" json:"settlement_id"` // 交易清算的交易ID
+	RecordID     string     `gorm:"type:varchar(100);index" json:"record_id"`    // 账单的交易ID
+	Account      string     `gorm:"type:varchar(100);index" json:"account"`
+	CardNumber   string     `gorm:"type:varchar(20)" json:"card_number"`
+	Method       string     `gorm:"type:varchar(10)" json:"method"`
+	Confidence   int        `gorm:"type:int" json:"confidence"`           // 0-100，人工匹配为100
+	Difference   Money      `gorm:"type:decimal(10,2)" json:"difference"` // 与该清算匹配的账单合计减清算金额
+	DaysApart    int        `gorm:"type:int" json:"days_apart"`
+	BatchID      uint       `gorm:"index" json:"batch_id"` // 自动匹配时所在的导入批次，回滚批次时一并删除
+	CreatedBy    string     `gorm:"type:varchar(100)" json:"created_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `gorm:"index" json:"revoked_at"` // 撤销后不再生效，自动核对也不会再匹配这一对
+	RevokedBy    string     `gorm:"type:varchar(100)" json:"revoked_by"`
+}
+
+// ReconcilePair 一笔账单与一笔交易清算
+type ReconcilePair struct{ RecordID, SettlementID string }
+
+// matchConfidence 自动匹配的可信度：日期每差一天减10，金额不完全相同减20，多笔合并减10
+func matchConfidence(m ReconcileMatch) int {
+	c := 100 - 10*m.DaysApart
+	if m.Difference != 0 {
+		c -= 20
+	}
+	if len(m.Charges) > 1 {
+		c -= 10
+	}
+	if c < 1 {
+		c = 1
+	}
+	return c
+}
+
+// saveMatches 保存自动匹配的结果并更新两边的标记，返回新增的匹配
+func saveMatches(tx *gorm.DB, matches []ReconcileMatch, batchID uint, user string) ([]Reconciliation, error) {
+	var rows []Reconciliation
+	var settlementIDs, recordIDs []string
+	for _, m := range matches {
+		confidence := matchConfidence(m)
+		for _, c := range m.Charges {
+			rows = append(rows, Reconciliation{
+				SettlementID: m.Settlement.TransactionID,
+				RecordID:     c.TransactionID,
+				Account:      c.Account,
+				CardNumber:   c.Card,
+				Method:       MatchAuto,
+				Confidence:   confidence,
+				Difference:   m.Difference,
+				DaysApart:    m.DaysApart,
+				BatchID:      batchID,
+				CreatedBy:    user,
+			})
+			recordIDs = append(recordIDs, c.TransactionID)
+		}
+		settlementIDs = append(settlementIDs, m.Settlement.TransactionID)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	if err := tx.CreateInBatches(&rows, 500).Error; err != nil {
+		return nil, err
+	}
+	return rows, syncMatchFlags(tx, settlementIDs, recordIDs)
+}
+
+// revokedPairs 已撤销的匹配，自动核对时排除
+func revokedPairs(tx *gorm.DB, recordIDs []string) (map[ReconcilePair]bool, error) {
+	pairs := map[ReconcilePair]bool{}
+	for start := 0; start < len(recordIDs); start += 1000 {
+		end := start + 1000
+		if end > len(recordIDs) {
+			end = len(recordIDs)
+		}
+		var rows []Reconciliation
+		if err := tx.Select("record_id, settlement_id").
+			Where("revoked_at IS NOT NULL AND record_id IN ?", recordIDs[start:end]).
+			Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			pairs[ReconcilePair{r.RecordID, r.SettlementID}] = true
+		}
+	}
+	return pairs, nil
+}
+
+// syncMatchFlags 按匹配表重新计算交易的 is_judge 和账单的 is_trading_authorization
+func syncMatchFlags(tx *gorm.DB, settlementIDs []string, recordIDs []string) error {
+	const chunk = 1000
+	for start := 0; start < len(settlementIDs); start += chunk {
+		end := start + chunk
+		if end > len(settlementIDs) {
+			end = len(settlementIDs)
+		}
+		if err := tx.Exec("UPDATE `transaction` SET is_judge = EXISTS (SELECT 1 FROM reconciliation r "+
+			"WHERE r.settlement_id = `transaction`.transaction_id AND r.revoked_at IS NULL) WHERE transaction_id IN ?",
+			settlementIDs[start:end]).Error; err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(recordIDs); start += chunk {
+		end := start + chunk
+		if end > len(recordIDs) {
+			end = len(recordIDs)
+		}
+		if err := tx.Exec("UPDATE transaction_record SET is_trading_authorization = EXISTS (SELECT 1 FROM reconciliation r "+
+			"WHERE r.record_id = transaction_record.transaction_id AND r.revoked_at IS NULL) WHERE transaction_id IN ?",
+			recordIDs[start:end]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteMatchesFor 删除匹配表中满足 where 条件的行（回滚批次时使用），并重新计算仍存在的另一方的标记
+func deleteMatchesFor(tx *gorm.DB, where string, args ...interface{}) error {
+	var rows []Reconciliation
+	if err := tx.Where(where, args...).Find(&rows).Error; err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(rows))
+	var settlementIDs, recordIDs []string
+	for _, r := range rows {
+		ids = append(ids, r.ID)
+		settlementIDs = append(settlementIDs, r.SettlementID)
+		recordIDs = append(recordIDs, r.RecordID)
+	}
+	if err := tx.Delete(&Reconciliation{}, ids).Error; err != nil {
+		return err
+	}
+	// 多笔账单合并的匹配可能只删除了其中一部分
+	if err := updateGroupDifferences(tx, uniqueStrings(settlementIDs)); err != nil {
+		return err
+	}
+	return syncMatchFlags(tx, settlementIDs, recordIDs)
+}
+
+// groupDifference 一笔交易清算与匹配的账单之间的差额（账单合计减清算金额）和账单日期相差的最大天数
+func groupDifference(settlement Transaction, records []TransactionRecord) (difference Money, days int) {
+	var sum Money
+	for _, r := range records {
+		sum += r.Amount.Abs()
+		if d, ok := daysBetween(r.Date, settlement.TransactionTime, SourceLocation(r.Platform)); ok && d > days {
+			days = d
+		}
+	}
+	return sum - settlement.OrderAmount.Abs(), days
+}
+
+// updateGroupDifferences 撤销或删除部分匹配后，按各清算仍生效的账单重新计算并保存差额和相差天数
+func updateGroupDifferences(tx *gorm.DB, settlementIDs []string) error {
+	const chunk = 1000
+	for start := 0; start < len(settlementIDs); start += chunk {
+		end := start + chunk
+		if end > len(settlementIDs) {
+			end = len(settlementIDs)
+		}
+		var active []Reconciliation
+		if err := tx.Select("settlement_id, record_id").
+			Where("revoked_at IS NULL AND settlement_id IN ?", settlementIDs[start:end]).
+			Find(&active).Error; err != nil {
+			return err
+		}
+		if len(active) == 0 {
+			continue
+		}
+		groups := map[string][]string{}
+		var ids, recordIDs []string
+		for _, a := range active {
+			if _, ok := groups[a.SettlementID]; !ok {
+				ids = append(ids, a.SettlementID)
+			}
+			groups[a.SettlementID] = append(groups[a.SettlementID], a.RecordID)
+			recordIDs = append(recordIDs, a.RecordID)
+		}
+		var settlements []Transaction
+		if err := tx.Select("transaction_id, transaction_time, order_amount").
+			Where("transaction_id IN ?", ids).Find(&settlements).Error; err != nil {
+			return err
+		}
+		var records []TransactionRecord
+		if err := tx.Select("transaction_id, date, amount, platform").
+			Where("transaction_id IN ?", recordIDs).Find(&records).Error; err != nil {
+			return err
+		}
+		recordByID := make(map[string]TransactionRecord, len(records))
+		for _, r := range records {
+			recordByID[r.TransactionID] = r
+		}
+		for _, settlement := range settlements {
+			group := make([]TransactionRecord, 0, len(groups[settlement.TransactionID]))
+			for _, id := range groups[settlement.TransactionID] {
+				group = append(group, recordByID[id])
+			}
+			difference, days := groupDifference(settlement, group)
+			if err := tx.Model(&Reconciliation{}).
+				Where("revoked_at IS NULL AND settlement_id = ?", settlement.TransactionID).
+				Updates(map[string]interface{}{"difference": difference, "days_apart": days}).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetReconciliations 分页查询匹配，settlementID、recordID、account 为空时不限制；
+// revoked 为真时包含已撤销的匹配
+func GetReconciliations(settlementID string, recordID string, account string, revoked bool, pageSize int, pageNum int) ([]Reconciliation, int64, error) {
+	var rows []Reconciliation
+	var total int64
+	query := db.Model(&Reconciliation{})
+	if settlementID != "" {
+		query = query.Where("settlement_id = ?", settlementID)
+	}
+	if recordID != "" {
+		query = query.Where("record_id = ?", recordID)
+	}
+	if account != "" {
+		query = query.Where("account = ?", account)
+	}
+	if !revoked {
+		query = query.Where("revoked_at IS NULL")
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id DESC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Find(&rows).Error
+	return rows, total, err
+}
+
+// ReconciliationDetail 一笔交易清算及与之匹配的账单
+type ReconciliationDetail struct {
+	Settlement Transaction         `json:"settlement"`
+	Records    []TransactionRecord `json:"records"`
+	Matches    []Reconciliation    `json:"matches"`
+}
+
+// GetReconciliation 查看一条匹配：所在的交易清算、与该清算匹配的所有账单及匹配记录
+func GetReconciliation(id uint) (*ReconciliationDetail, error) {
+	var match Reconciliation
+	if err := db.First(&match, id).Error; err != nil {
+		return nil, err
+	}
+	detail := &ReconciliationDetail{}
+	if err := db.Where("transaction_id = ?", match.SettlementID).Limit(1).Find(&detail.Settlement).Error; err != nil {
+		return nil, err
+	}
+	query := db.Where("settlement_id = ?", match.SettlementID)
+	if match.RevokedAt != nil {
+		query = db.Where("id = ?", match.ID)
+	} else {
+		query = query.Where("revoked_at IS NULL")
+	}
+	if err := query.Order("id ASC").Find(&detail.Matches).Error; err != nil {
+		return nil, err
+	}
+	recordIDs := make([]string, 0, len(detail.Matches))
+	for _, m := range detail.Matches {
+		recordIDs = append(recordIDs, m.RecordID)
+	}
+	if err := db.Where("transaction_id IN ?", recordIDs).Order("date ASC").Find(&detail.Records).Error; err != nil {
+		return nil, err
+	}
+	return detail, nil
+}
+
+// MatchManually 人工把一笔或多笔账单匹配到一笔交易清算。账单已有生效的匹配时需要先撤销；
+// 清算已有匹配时新账单并入该清算
+func MatchManually(settlementID string, recordIDs []string, user string) ([]Reconciliation, error) {
+	if settlementID == "" || len(recordIDs) == 0 {
+		return nil, errors.New("需要指定交易清算和至少一笔账单")
+	}
+	var rows []Reconciliation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var settlement Transaction
+		if err := tx.Where("transaction_id = ?", settlementID).Limit(1).Find(&settlement).Error; err != nil {
+			return err
+		}
+		if settlement.TransactionID == "" {
+			return fmt.Errorf("交易 %s 不存在", settlementID)
+		}
+		if settlement.TransactionType != TypeSettlement {
+			return fmt.Errorf("交易 %s 是%s，不是%s", settlementID, settlement.TransactionType, TypeSettlement)
+		}
+
+		var records []TransactionRecord
+		if err := tx.Where("transaction_id IN ?", recordIDs).Find(&records).Error; err != nil {
+			return err
+		}
+		if len(records) != len(uniqueStrings(recordIDs)) {
+			return errors.New("部分账单不存在")
+		}
+		if err := checkMatchPeriods(tx, settlementID, recordIDs); err != nil {
+			return err
+		}
+		var active []Reconciliation
+		if err := tx.Where("revoked_at IS NULL AND record_id IN ?", recordIDs).Find(&active).Error; err != nil {
+			return err
+		}
+		if len(active) > 0 {
+			return fmt.Errorf("账单 %s 已匹配到交易清算 %s，请先撤销", active[0].RecordID, active[0].SettlementID)
+		}
+
+		// 差额和日期按该清算的全部账单计算
+		var existing []Reconciliation
+		if err := tx.Where("revoked_at IS NULL AND settlement_id = ?", settlementID).Find(&existing).Error; err != nil {
+			return err
+		}
+		var matchedRecords []TransactionRecord
+		if len(existing) > 0 {
+			ids := make([]string, 0, len(existing))
+			for _, e := range existing {
+				ids = append(ids, e.RecordID)
+			}
+			if err := tx.Where("transaction_id IN ?", ids).Find(&matchedRecords).Error; err != nil {
+				return err
+			}
+		}
+		difference, days := groupDifference(settlement, append(matchedRecords, records...))
+
+		for _, r := range records {
+			rows = append(rows, Reconciliation{
+				SettlementID: settlementID,
+				RecordID:     r.TransactionID,
+				Account:      r.Account,
+				CardNumber:   r.PaymentMethod,
+				Method:       MatchManual,
+				Confidence:   100,
+				Difference:   difference,
+				DaysApart:    days,
+				CreatedBy:    user,
+			})
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			if err := tx.Model(&Reconciliation{}).Where("revoked_at IS NULL AND settlement_id = ?", settlementID).
+				Updates(map[string]interface{}{"difference": difference, "days_apart": days}).Error; err != nil {
+				return err
+			}
+		}
+		return syncMatchFlags(tx, []string{settlementID}, recordIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Unmatch 撤销一条匹配。多笔账单合并的匹配只撤销这一笔账单，其余账单仍匹配该清算，差额按剩下的账单重新计算
+func Unmatch(id uint, user string) (*Reconciliation, error) {
+	var match Reconciliation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&match, id).Error; err != nil {
+			return err
+		}
+		if match.RevokedAt != nil {
+			return fmt.Errorf("匹配 %d 已于 %s 撤销", match.ID, match.RevokedAt.Format("2006-01-02 15:04:05"))
+		}
+		if err := checkMatchPeriods(tx, match.SettlementID, []string{match.RecordID}); err != nil {
+			return err
+		}
+		now := time.Now()
+		match.RevokedAt = &now
+		match.RevokedBy = user
+		if err := tx.Save(&match).Error; err != nil {
+			return err
+		}
+		if err := updateGroupDifferences(tx, []string{match.SettlementID}); err != nil {
+			return err
+		}
+		return syncMatchFlags(tx, []string{match.SettlementID}, []string{match.RecordID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &match, nil
+}
+
+// daysBetween 账单日期与清算时间（按平台时区取日期）相差的天数，账单日期无效时 ok 为 false
+func daysBetween(date Date, t time.Time, loc *time.Location) (days int, ok bool) {
+	day, err := time.Parse(DateLayout, string(date))
+	if err != nil {
+		return 0, false
+	}
+	st := t.In(loc)
+	settleDay := time.Date(st.Year(), st.Month(), st.Day(), 0, 0, 0, 0, time.UTC)
+	return int(math.Abs(settleDay.Sub(day).Hours() / 24)), true
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// backfillReconciliations 匹配表为空而已有核对标记时（升级前的数据），按原来的规则
+// （同账户、同卡、金额完全相同，不限日期，一对一）把已标记的账单与清算配对写入匹配表，
+// 然后按匹配表重新计算所有标记，无法配对的标记被清除，会在下一次核对时重新匹配
+func backfillReconciliations() error {
+	var count int64
+	if err := db.Model(&Reconciliation{}).Count(&count).Error; err != nil || count > 0 {
+		return err
+	}
+	var flagged int64
+	if err := db.Model(&TransactionRecord{}).Where("is_trading_authorization = ?", true).Count(&flagged).Error; err != nil || flagged == 0 {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		var records []TransactionRecord
+		if err := tx.Select("transaction_id, account, payment_method, date, platform, amount").
+			Where("is_trading_authorization = ?", true).Find(&records).Error; err != nil {
+			return err
+		}
+		var settlements []Transaction
+		if err := tx.Select("transaction_id, nickname, card_number, transaction_time, order_amount").
+			Where("transaction_type = ? AND is_judge = ?", TypeSettlement, true).Find(&settlements).Error; err != nil {
+			return err
+		}
+		charges := make([]ReconcileCharge, 0, len(records))
+		recordIDs := make([]string, 0, len(records))
+		for _, r := range records {
+			charges = append(charges, ReconcileCharge{TransactionID: r.TransactionID, Account: r.Account, Card: r.PaymentMethod,
+				Date: r.Date, Platform: r.Platform, Amount: r.Amount.Abs()})
+			recordIDs = append(recordIDs, r.TransactionID)
+		}
+		candidates := make([]ReconcileSettlement, 0, len(settlements))
+		settlementIDs := make([]string, 0, len(settlements))
+		for _, t := range settlements {
+			candidates = append(candidates, ReconcileSettlement{TransactionID: t.TransactionID, Account: t.Nickname, Card: t.CardNumber,
+				Time: t.TransactionTime, Amount: t.OrderAmount.Abs()})
+			settlementIDs = append(settlementIDs, t.TransactionID)
+		}
+		legacy := ReconcileOptions{WindowDays: math.MaxInt32, MaxGroup: 1}
+		if _, err := saveMatches(tx, Reconcile(charges, candidates, legacy), 0, "migration"); err != nil {
+			return err
+		}
+		return syncMatchFlags(tx, settlementIDs, recordIDs)
+	})
+}
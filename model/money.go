@@ -0,0 +1,127 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money 金额，以分为单位的定点数，对应数据库中的 decimal(10,2)。
+// 从解析、存储、汇总到匹配都按分精确计算，JSON 中输出为 "12.34" 形式的字符串
+type Money int64
+
+// ParseMoney 解析十进制金额，如 "-12.34"、"+5"、".5"。超过两位的小数按四舍五入到分
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	orig := s
+	neg := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	if intPart == "" && fracPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("invalid amount %q", orig)
+	}
+
+	var units int64
+	if intPart != "" {
+		v, err := strconv.ParseInt(intPart, 10, 64)
+		if err != nil || v > (1<<62)/100 {
+			return 0, fmt.Errorf("amount out of range %q", orig)
+		}
+		units = v * 100
+	}
+	cents := (fracPart + "00")[:2]
+	c, _ := strconv.ParseInt(cents, 10, 64)
+	units += c
+	if len(fracPart) > 2 && fracPart[2] >= '5' {
+		units++ // 四舍五入到分
+	}
+	if neg {
+		units = -units
+	}
+	return Money(units), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String 两位小数的十进制表示，如 "-12.34"
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// Abs 绝对值
+func (m Money) Abs() Money {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Value 以十进制字符串写入数据库，避免经过浮点数
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan 读取 decimal 列或 SUM() 的结果，NULL 视为0
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case []byte:
+		return m.parse(string(v))
+	case string:
+		return m.parse(v)
+	case int64:
+		*m = Money(v * 100)
+		return nil
+	case float64:
+		// 只有驱动把 decimal 当作浮点数返回时才会出现，按两位小数格式化后解析
+		return m.parse(strconv.FormatFloat(v, 'f', 2, 64))
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+}
+
+func (m *Money) parse(s string) error {
+	v, err := ParseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// MarshalJSON 输出为字符串，前端不会因为浮点数丢失精度
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON 接受字符串或数字
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*m = 0
+		return nil
+	}
+	return m.parse(s)
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{"12.34", 1234, false},
+		{"-12.34", -1234, false},
+		{"+5", 500, false},
+		{".5", 50, false},
+		{"7.", 700, false},
+		{" 0.01 ", 1, false},
+		{"-0", 0, false},
+		{"1.004", 100, false}, // 四舍五入到分
+		{"1.005", 101, false},
+		{"-1.005", -101, false}, // 负数向远离0的方向舍入
+		{"0.999", 100, false},
+		{"1,234.56", 0, true}, // 千分位由 ParseAmount 去掉
+		{"", 0, true},
+		{".", 0, true},
+		{"-", 0, true},
+		{"1.2.3", 0, true},
+		{"1e3", 0, true},
+		{"$5", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseMoney(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Money
+	}{
+		{"1,234.56", 123456},
+		{"-1,234,567.8", -123456780},
+		{"12", 1200},
+	}
+	for _, tt := range tests {
+		if got, err := ParseAmount(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseAmount(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestMoneyStringAndJSON(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{-5, "-0.05"},
+		{123456, "1234.56"},
+		{-100, "-1.00"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.m), got, tt.want)
+		}
+		data, err := json.Marshal(tt.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Money
+		if err := json.Unmarshal(data, &back); err != nil || back != tt.m {
+			t.Errorf("JSON round trip of %v = %v, %v", tt.m, back, err)
+		}
+	}
+	var n Money
+	if err := json.Unmarshal([]byte("12.3"), &n); err != nil || n != 1230 {
+		t.Errorf("Unmarshal(12.3) = %v, %v; want 12.30", n, err)
+	}
+}
+
+func TestMoneyScan(t *testing.T) {
+	tests := []struct {
+		src  interface{}
+		want Money
+	}{
+		{nil, 0},
+		{[]byte("12.34"), 1234},
+		{"-0.50", -50},
+		{int64(3), 300},
+		{float64(0.1 + 0.2), 30},
+	}
+	for _, tt := range tests {
+		var m Money
+		if err := m.Scan(tt.src); err != nil || m != tt.want {
+			t.Errorf("Scan(%v) = %v, %v; want %v", tt.src, m, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MonthLayout 结账期间的格式
+const MonthLayout = "2006-01"
+
+// 结账记录的操作
+const (
+	PeriodLockAction   = "lock"
+	PeriodUnlockAction = "unlock"
+)
+
+// RoleAdmin 管理员的角色码，只有管理员可以解锁已结账的期间
+const RoleAdmin = 1
+
+// ErrPeriodLocked 要修改的数据所在的期间已结账
+var ErrPeriodLocked = errors.New("期间已结账")
+
+// PeriodLock 已结账的期间：广告账户某月的账单、交易清算和核对结果不再随导入或编辑变化。
+// Account 为空表示所有账户。账单按账单日期、虚拟卡交易按默认时区中的交易时间归入月份，
+// 虚拟卡交易的昵称即广告账户
+type PeriodLock struct {
+	ID       uint      `gorm:"primaryKey" json:"id"`
+	Account  string    `gorm:"type:varchar(100);uniqueIndex:idx_period_lock" json:"account"`
+	Month    string    `gorm:"type:varchar(7);uniqueIndex:idx_period_lock" json:"month"`
+	Reason   string    `gorm:"type:varchar(500)" json:"reason"`
+	LockedBy string    `gorm:"type:varchar(100)" json:"locked_by"`
+	LockedAt time.Time `json:"locked_at"`
+}
+
+// PeriodLockEvent 一次结账或解锁的记录
+type PeriodLockEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Account   string    `gorm:"type:varchar(100);index" json:"account"`
+	Month     string    `gorm:"type:varchar(7);index" json:"month"`
+	Action    string    `gorm:"type:varchar(10)" json:"action"`
+	Reason    string    `gorm:"type:varchar(500)" json:"reason"`
+	User      string    `gorm:"type:varchar(100)" json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// normalizePeriod 校验月份和原因
+func normalizePeriod(account string, month string, reason string) (string, string, string, error) {
+	account, month, reason = strings.TrimSpace(account), strings.TrimSpace(month), strings.TrimSpace(reason)
+	if _, err := time.Parse(MonthLayout, month); err != nil {
+		return "", "", "", fmt.Errorf("无效的月份 %q，格式为 2006-01", month)
+	}
+	if reason == "" {
+		return "", "", "", errors.New("需要填写原因")
+	}
+	if len([]rune(reason)) > 500 {
+		reason = string([]rune(reason)[:500])
+	}
+	return account, month, reason, nil
+}
+
+// LockPeriod 结账：锁定广告账户某月，account 为空时锁定所有账户
+func LockPeriod(account string, month string, reason string, user string) (*PeriodLock, error) {
+	account, month, reason, err := normalizePeriod(account, month, reason)
+	if err != nil {
+		return nil, err
+	}
+	lock := PeriodLock{Account: account, Month: month, Reason: reason, LockedBy: user, LockedAt: time.Now()}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&PeriodLock{}).Where("account = ? AND month = ?", account, month).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("%s %s 已结账", periodName(account), month)
+		}
+		if err := tx.Create(&lock).Error; err != nil {
+			return err
+		}
+		return tx.Create(&PeriodLockEvent{Account: account, Month: month, Action: PeriodLockAction, Reason: reason, User: user}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// UnlockPeriod 解锁已结账的期间，只有管理员可以操作
+func UnlockPeriod(account string, month string, reason string, user string) error {
+	account, month, reason, err := normalizePeriod(account, month, reason)
+	if err != nil {
+		return err
+	}
+	var admin User
+	if err := db.Select("id, role").Where("username = ?", user).Limit(1).Find(&admin).Error; err != nil {
+		return err
+	}
+	if admin.ID == 0 || admin.Role != RoleAdmin {
+		return errors.New("只有管理员可以解锁已结账的期间")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("account = ? AND month = ?", account, month).Delete(&PeriodLock{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%s %s 没有结账", periodName(account), month)
+		}
+		return tx.Create(&PeriodLockEvent{Account: account, Month: month, Action: PeriodUnlockAction, Reason: reason, User: user}).Error
+	})
+}
+
+// GetPeriodLocks 查询已结账的期间，account 不为空时包含该账户和所有账户的锁定
+func GetPeriodLocks(account string) ([]PeriodLock, error) {
+	var locks []PeriodLock
+	query := db.Model(&PeriodLock{})
+	if account != "" {
+		query = query.Where("account IN ?", []string{account, ""})
+	}
+	err := query.Order("month DESC, account ASC").Find(&locks).Error
+	return locks, err
+}
+
+// GetPeriodLockEvents 分页查询结账和解锁记录，account、month 为空时不限制
+func GetPeriodLockEvents(account string, month string, pageSize int, pageNum int) ([]PeriodLockEvent, int64, error) {
+	var events []PeriodLockEvent
+	var total int64
+	query := db.Model(&PeriodLockEvent{})
+	if account != "" {
+		query = query.Where("account = ?", account)
+	}
+	if month != "" {
+		query = query.Where("month = ?", month)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id DESC").
+		Limit(pageSize).
+		Offset((pageNum - 1) * pageSize).
+		Find(&events).Error
+	return events, total, err
+}
+
+func periodName(account string) string {
+	if account == "" {
+		return "所有账户"
+	}
+	return "账户 " + account
+}
+
+// periodLocks 已结账的期间，键为 账户|月份，所有账户的锁定账户为空
+type periodLocks map[string]bool
+
+// loadPeriodLocks 读取全部已结账的期间
+func loadPeriodLocks(tx *gorm.DB) (periodLocks, error) {
+	var locks []PeriodLock
+	if err := tx.Select("account, month").Find(&locks).Error; err != nil {
+		return nil, err
+	}
+	set := make(periodLocks, len(locks))
+	for _, l := range locks {
+		set[l.Account+"|"+l.Month] = true
+	}
+	return set, nil
+}
+
+// locked 账户的某月是否已结账
+func (p periodLocks) locked(account string, month string) bool {
+	return p["|"+month] || p[account+"|"+month]
+}
+
+// recordMonth 账单所在的月份
+func recordMonth(date Date) string {
+	return firstN(string(date), len(MonthLayout))
+}
+
+// transactionMonth 虚拟卡交易在默认时区中所在的月份
+func transactionMonth(t time.Time) string {
+	return t.In(defaultLocation()).Format(MonthLayout)
+}
+
+// checkRecord 账单所在的期间已结账时返回 ErrPeriodLocked
+func (p periodLocks) checkRecord(account string, date Date) error {
+	if month := recordMonth(date); p.locked(account, month) {
+		return fmt.Errorf("%w：%s %s", ErrPeriodLocked, periodName(account), month)
+	}
+	return nil
+}
+
+// checkTransaction 虚拟卡交易所在的期间已结账时返回 ErrPeriodLocked
+func (p periodLocks) checkTransaction(nickname string, t time.Time) error {
+	if month := transactionMonth(t); p.locked(nickname, month) {
+		return fmt.Errorf("%w：%s %s", ErrPeriodLocked, periodName(nickname), month)
+	}
+	return nil
+}
+
+// checkMatchPeriods 人工匹配或撤销匹配涉及的交易清算和账单所在的期间已结账时返回 ErrPeriodLocked
+func checkMatchPeriods(tx *gorm.DB, settlementID string, recordIDs []string) error {
+	locks, err := loadPeriodLocks(tx)
+	if err != nil || len(locks) == 0 {
+		return err
+	}
+	var settlement Transaction
+	if err := tx.Select("transaction_id, nickname, transaction_time").Where("transaction_id = ?", settlementID).
+		Limit(1).Find(&settlement).Error; err != nil {
+		return err
+	}
+	if settlement.TransactionID != "" {
+		if err := locks.checkTransaction(settlement.Nickname, settlement.TransactionTime); err != nil {
+			return err
+		}
+	}
+	var records []TransactionRecord
+	if err := tx.Select("transaction_id, account, date").Where("transaction_id IN ?", recordIDs).Find(&records).Error; err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := locks.checkRecord(r.Account, r.Date); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBatchPeriods 回滚批次会删除已结账期间的数据时返回 ErrPeriodLocked
+func checkBatchPeriods(tx *gorm.DB, batch ImportBatch) error {
+	locks, err := loadPeriodLocks(tx)
+	if err != nil || len(locks) == 0 {
+		return err
+	}
+	switch batch.Kind {
+	case ImportKindCard:
+		var rows []Transaction
+		if err := tx.Model(&Transaction{}).Distinct("nickname", "transaction_time").
+			Where("batch_id = ?", batch.ID).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, t := range rows {
+			if err := locks.checkTransaction(t.Nickname, t.TransactionTime); err != nil {
+				return err
+			}
+		}
+	case ImportKindBilling:
+		var rows []TransactionRecord
+		if err := tx.Model(&TransactionRecord{}).Distinct("account", "date").
+			Where("batch_id = ?", batch.ID).Find(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if err := locks.checkRecord(r.Account, r.Date); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package model
+
+import (
+	"app/utils"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestReimportIntoLockedPeriod 已结账月份中的交易重新导入时不能被修改，需要设置 WB_BENCH_DSN
+func TestReimportIntoLockedPeriod(t *testing.T) {
+	openBenchDB(t, &Transaction{}, &ImportBatch{}, &ImportReject{}, &TransactionChange{},
+		&AuthorizationLink{}, &PeriodLock{}, &PeriodLockEvent{})
+	savedTimezone := utils.Timezone
+	utils.Timezone = "UTC"
+	t.Cleanup(func() { utils.Timezone = savedTimezone })
+
+	const account, month = "LOCKTEST", "2024-03"
+	cleanup := func() {
+		db.Where("nickname = ?", account).Delete(&Transaction{})
+		db.Where("account = ?", account).Delete(&PeriodLock{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	f, err := os.CreateTemp(t.TempDir(), "locktest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	row := func(status string, amount Money) ParsedTransaction {
+		return ParsedTransaction{Row: 2, Transaction: Transaction{
+			TransactionID:     "LOCKTEST-1",
+			TransactionTime:   time.Date(2024, 3, 15, 8, 0, 0, 0, time.UTC),
+			CardNumber:        "1234",
+			Nickname:          account,
+			TransactionType:   TypeAuthorization,
+			OrderAmount:       -amount,
+			OrderCurrency:     "USD",
+			TransactionAmount: -amount,
+			TransactionStatus: status,
+		}}
+	}
+	if _, err := ImportTransactions(sliceReader{row("处理中", 10000)}, f.Name(), ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LockPeriod(account, month, "test", "tester"); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := sliceReader{row("成功", 12000)}
+	preview, err := PreviewTransactions(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Updated != 0 || len(preview.Skipped) != 1 || !strings.Contains(preview.Skipped[0].Reason, ErrPeriodLocked.Error()) {
+		t.Errorf("preview: updated %d, skipped %+v, want the row rejected as locked", preview.Updated, preview.Skipped)
+	}
+
+	for _, bestEffort := range []bool{false, true} {
+		batch, err := ImportTransactions(changed, f.Name(), ImportOptions{BestEffort: bestEffort})
+		if bestEffort != (err == nil) {
+			t.Errorf("best effort %v: err = %v", bestEffort, err)
+		}
+		if batch.UpdatedRows != 0 || batch.SkippedRows != 1 {
+			t.Errorf("best effort %v: updated %d, skipped %d, want 0 and 1", bestEffort, batch.UpdatedRows, batch.SkippedRows)
+		}
+		var stored Transaction
+		if err := db.First(&stored, "transaction_id = ?", "LOCKTEST-1").Error; err != nil {
+			t.Fatal(err)
+		}
+		if stored.TransactionStatus != "处理中" || stored.TransactionAmount != -10000 {
+			t.Errorf("best effort %v: stored %s %v, want the locked row unchanged", bestEffort, stored.TransactionStatus, stored.TransactionAmount)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package model
+
+import "gorm.io/gorm"
+
+// ImportPreview 导入预览结果：只解析文件并对照数据库，不写入任何数据
+type ImportPreview struct {
+	Total    int               `json:"total"`    // 解析出的数据行数
+	New      int               `json:"new"`      // 将新增的行数
+	Existing int               `json:"existing"` // 按 TransactionID 已存在的行数
+	Updated  int               `json:"updated"`  // 已存在且状态等字段将被更新的行数（仅虚拟卡文件）
+	Skipped  []RejectedRow     `json:"skipped"`  // 将被拒绝的行及原因
+	Warnings []RejectedRow     `json:"warnings"` // 可以导入但数据可疑的行
+	Matches  []SettlementMatch `json:"matches"`  // FB 账单将匹配到的交易清算（仅账单文件）
+	Accounts []AccountRowCount `json:"accounts"` // 按广告账户统计的行数及总计核对（仅账单文件）
+
+	StatusChanged  int  `json:"status_changed"`  // 已存在且交易状态或清算状态将变化的行数
+	TotalsMismatch bool `json:"totals_mismatch"` // 有账户的总计行与将导入的金额不符
+}
+
+// SettlementMatch 账单行与交易清算的匹配关系，多笔账单合并为一笔清算时每笔账单各一条，SettlementID 相同
+type SettlementMatch struct {
+	Row              int    `json:"row"`            // 在文件中的行号，数据库中之前未核对的账单为0
+	TransactionID    string `json:"transaction_id"` // 账单的交易ID
+	SettlementID     string `json:"settlement_id"`  // 交易清算的交易ID
+	Account          string `json:"account"`
+	CardNumber       string `json:"card_number"`
+	Amount           Money  `json:"amount"`
+	SettlementAmount Money  `json:"settlement_amount"`
+	Difference       Money  `json:"difference"` // 与该清算匹配的账单合计减清算金额
+	DaysApart        int    `json:"days_apart"`
+}
+
+func newImportPreview() *ImportPreview {
+	return &ImportPreview{Skipped: []RejectedRow{}, Warnings: []RejectedRow{}, Matches: []SettlementMatch{}}
+}
+
+func (p *ImportPreview) addReject(r RejectedRow) {
+	if r.Warning {
+		p.Warnings = append(p.Warnings, r)
+	} else {
+		p.Skipped = append(p.Skipped, r)
+	}
+}
+
+// PreviewTransactions 预览虚拟卡文件的导入结果
+func PreviewTransactions(reader TransactionReader) (*ImportPreview, error) {
+	locks, err := loadPeriodLocks(db)
+	if err != nil {
+		return nil, err
+	}
+	preview := newImportPreview()
+	ids := make([]string, 0, importChunkSize)
+	parsed := make([]ParsedTransaction, 0, importChunkSize)
+	count := func() error {
+		existing, err := existingTransactions(db, ids)
+		if err != nil {
+			return err
+		}
+		preview.Existing += len(existing)
+		for _, p := range parsed {
+			old, ok := existing[p.TransactionID]
+			if !ok {
+				if err := locks.checkTransaction(p.Nickname, p.TransactionTime); err != nil {
+					preview.addReject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: err.Error()})
+				} else {
+					preview.New++
+				}
+				continue
+			}
+			changes, statusChanged := transactionChanges(old, p)
+			if len(changes) == 0 {
+				continue
+			}
+			if err := locks.checkTransaction(old.Nickname, old.TransactionTime); err != nil {
+				preview.addReject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: err.Error() + "，不能修改"})
+				continue
+			}
+			preview.Updated++
+			if statusChanged {
+				preview.StatusChanged++
+			}
+		}
+		ids, parsed = ids[:0], parsed[:0]
+		return nil
+	}
+
+	err = eachTransaction(reader, func(p ParsedTransaction) error {
+		preview.Total++
+		ids = append(ids, p.TransactionID)
+		parsed = append(parsed, p)
+		if len(ids) >= importChunkSize {
+			return count()
+		}
+		return nil
+	}, func(r RejectedRow) {
+		if !r.Warning {
+			preview.Total++
+		}
+		preview.addReject(r)
+	})
+	if err == nil {
+		err = count()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// PreviewTransactionRecords 预览 platform 广告平台账单的导入结果，
+// 包括每一行将会匹配到的交易清算；同一条清算只会被文件中靠前的一行匹配
+func PreviewTransactionRecords(billing *BillingFile, platform string) (*ImportPreview, error) {
+	preview := newImportPreview()
+	preview.Total = len(billing.Rows)
+	for _, r := range billing.Rejects {
+		if !r.Warning {
+			preview.Total++
+		}
+		preview.addReject(r)
+	}
+
+	locks, err := loadPeriodLocks(db)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(billing.Rows))
+	for _, p := range billing.Rows {
+		ids = append(ids, p.TransactionID)
+	}
+	found, err := existingRecords(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	accounts := newAccountCounter(billing.Sections)
+	var charges []ReconcileCharge
+	var rows []TransactionRecord
+	for _, p := range billing.Rows {
+		account := accounts.get(p.Account)
+		account.Rows++
+		if existing, ok := found[p.TransactionID]; ok {
+			if err := checkRecordOwner(existing, p.Account, platform); err != nil {
+				preview.addReject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: err.Error()})
+				account.Skipped++
+				continue
+			}
+			preview.Existing++
+			account.Existing++
+		} else if err := locks.checkRecord(p.Account, p.Date); err != nil {
+			preview.addReject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: err.Error()})
+			account.Skipped++
+			continue
+		} else {
+			preview.New++
+			account.Inserted++
+			charges = append(charges, ReconcileCharge{TransactionID: p.TransactionID, Account: p.Account, Card: p.PaymentMethod,
+				Date: p.Date, Platform: platform, Amount: p.Amount.Abs(), Row: p.Row})
+		}
+		account.Imported += p.Amount
+		rows = append(rows, p.TransactionRecord)
+	}
+
+	// 与导入时一样，新账单连同数据库中未核对的账单一起核对
+	existing, settlements, err := unmatchedForKeys(db, reconcileKeys(rows), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	opts := DefaultReconcileOptions()
+	recordIDs := make([]string, 0, len(existing))
+	for _, c := range existing {
+		recordIDs = append(recordIDs, c.TransactionID)
+	}
+	if opts.Exclude, err = revokedPairs(db, recordIDs); err != nil {
+		return nil, err
+	}
+	for _, m := range Reconcile(append(charges, existing...), settlements, opts) {
+		for _, c := range m.Charges {
+			accounts.get(c.Account).Matched++
+			preview.Matches = append(preview.Matches, SettlementMatch{
+				Row:              c.Row,
+				TransactionID:    c.TransactionID,
+				SettlementID:     m.Settlement.TransactionID,
+				Account:          c.Account,
+				CardNumber:       c.Card,
+				Amount:           c.Amount,
+				SettlementAmount: m.Settlement.Amount,
+				Difference:       m.Difference,
+				DaysApart:        m.DaysApart,
+			})
+		}
+	}
+	preview.TotalsMismatch = accounts.checkTotals()
+	preview.Accounts = accounts.list
+	return preview, nil
+}
+
+// existingRecords 返回 ids 中已存在于 transaction_record 表的账单，只取出交易ID、账户和平台
+func existingRecords(tx *gorm.DB, ids []string) (map[string]TransactionRecord, error) {
+	existing := make(map[string]TransactionRecord, len(ids))
+	// 分批查询，避免 IN 子句过长
+	const batch = 1000
+	for start := 0; start < len(ids); start += batch {
+		end := start + batch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var found []TransactionRecord
+		if err := tx.Model(&TransactionRecord{}).
+			Select("transaction_id, account, platform").
+			Where("transaction_id IN ?", ids[start:end]).
+			Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, r := range found {
+			existing[r.TransactionID] = r
+		}
+	}
+	return existing, nil
+}
+
+// existingTransactions 返回 ids 中已存在于 transaction 表的交易，只取出交易ID、会变化的字段，
+// 以及检查结账期间所需的昵称和交易时间
+func existingTransactions(tx *gorm.DB, ids []string) (map[string]Transaction, error) {
+	existing := make(map[string]Transaction, len(ids))
+	// 分批查询，避免 IN 子句过长
+	const batch = 1000
+	for start := 0; start < len(ids); start += batch {
+		end := start + batch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var found []Transaction
+		if err := tx.Table("transaction").
+			Select(append(mutableColumns(), "nickname", "transaction_time")).
+			Where("transaction_id IN ?", ids[start:end]).
+			Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, t := range found {
+			existing[t.TransactionID] = t
+		}
+	}
+	return existing, nil
+}
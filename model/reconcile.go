@@ -0,0 +1,508 @@
+package model
+
+import (
+	"app/utils"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReconcileOptions 账单与交易清算的核对规则
+type ReconcileOptions struct {
+	Tolerance  Money // 金额允许的差额
+	WindowDays int   // 账单日期与清算日期最多相差的天数
+	MaxGroup   int   // 一笔清算最多合并几笔账单，1 表示只做一对一匹配
+	// Exclude 不能匹配的账单与清算，即被人工撤销过的匹配
+	Exclude map[ReconcilePair]bool
+}
+
+// DefaultReconcileOptions 配置文件 [reconcile] 中的核对规则
+func DefaultReconcileOptions() ReconcileOptions {
+	tolerance, err := ParseMoney(utils.AmountTolerance)
+	if err != nil {
+		log.Printf("Invalid AmountTolerance %q, using 0: %v\n", utils.AmountTolerance, err)
+	}
+	opts := ReconcileOptions{Tolerance: tolerance.Abs(), WindowDays: utils.DateWindowDays, MaxGroup: utils.MaxGroupSize}
+	if opts.WindowDays < 0 {
+		opts.WindowDays = 0
+	}
+	if opts.MaxGroup < 1 {
+		opts.MaxGroup = 1
+	}
+	return opts
+}
+
+// ReconcileCharge 参与核对的账单行（卡上的一笔扣款）
+type ReconcileCharge struct {
+	TransactionID string
+	Account       string
+	Card          string // 卡号后四位
+	Date          Date   // 平台时区中的账单日期
+	Platform      string
+	Amount        Money // 正数
+	Row           int   // 在导入文件中的行号，已在数据库中的账单为0
+}
+
+// ReconcileSettlement 参与核对的交易清算
+type ReconcileSettlement struct {
+	TransactionID string
+	Account       string // 卡昵称，即广告账户
+	Card          string
+	Time          time.Time
+	Amount        Money // 正数
+}
+
+// ReconcileMatch 一笔交易清算与对应的一笔或多笔账单
+type ReconcileMatch struct {
+	Settlement ReconcileSettlement
+	Charges    []ReconcileCharge
+	Difference Money // 账单合计减清算金额
+	DaysApart  int   // 账单日期与清算日期相差的最大天数
+}
+
+// chargeSum 匹配的账单金额合计
+func (m ReconcileMatch) chargeSum() Money {
+	var sum Money
+	for _, c := range m.Charges {
+		sum += c.Amount
+	}
+	return sum
+}
+
+// maxGroupCandidates 合并匹配时每笔清算只在日期最近的若干笔账单中组合，限制组合数量
+const maxGroupCandidates = 12
+
+// Reconcile 在同一广告账户、同一张卡的账单与交易清算之间做匹配，不访问数据库：
+//  1. 一对一：金额差在 Tolerance 之内、日期相差不超过 WindowDays 的账单与清算中，
+//     优先匹配日期最近的，其次金额差最小的，每笔账单和清算最多使用一次；
+//  2. 多对一：剩下的清算再尝试由 2 到 MaxGroup 笔剩下的账单合计匹配，优先日期最近的组合。
+//
+// 结果按清算时间排列，相同输入的结果总是相同
+func Reconcile(charges []ReconcileCharge, settlements []ReconcileSettlement, opts ReconcileOptions) []ReconcileMatch {
+	type key struct{ Account, Card string }
+	chargeGroups := map[key][]ReconcileCharge{}
+	for _, c := range charges {
+		k := key{c.Account, c.Card}
+		chargeGroups[k] = append(chargeGroups[k], c)
+	}
+	settlementGroups := map[key][]ReconcileSettlement{}
+	for _, s := range settlements {
+		k := key{s.Account, s.Card}
+		settlementGroups[k] = append(settlementGroups[k], s)
+	}
+
+	locations := map[string]*time.Location{}
+	var matches []ReconcileMatch
+	for k, group := range chargeGroups {
+		if len(settlementGroups[k]) == 0 {
+			continue
+		}
+		matches = append(matches, reconcileGroup(group, settlementGroups[k], opts, locations)...)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i].Settlement, matches[j].Settlement
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return a.TransactionID < b.TransactionID
+	})
+	return matches
+}
+
+// reconcileGroup 一个账户、一张卡内的匹配
+func reconcileGroup(charges []ReconcileCharge, settlements []ReconcileSettlement, opts ReconcileOptions, locations map[string]*time.Location) []ReconcileMatch {
+	// daysApart 清算日期按账单所属平台的时区计算，与账单日期比较
+	// 被排除的组合和账单日期无效的返回 -1
+	daysApart := func(c ReconcileCharge, s ReconcileSettlement) int {
+		if opts.Exclude[ReconcilePair{c.TransactionID, s.TransactionID}] {
+			return -1
+		}
+		loc, ok := locations[c.Platform]
+		if !ok {
+			loc = SourceLocation(c.Platform)
+			locations[c.Platform] = loc
+		}
+		days, ok := daysBetween(c.Date, s.Time, loc)
+		if !ok {
+			return -1
+		}
+		return days
+	}
+
+	type pair struct {
+		c, s int
+		days int
+		diff Money
+	}
+	var pairs []pair
+	for ci, c := range charges {
+		for si, s := range settlements {
+			diff := (c.Amount - s.Amount).Abs()
+			if diff > opts.Tolerance {
+				continue
+			}
+			days := daysApart(c, s)
+			if days < 0 || days > opts.WindowDays {
+				continue
+			}
+			pairs = append(pairs, pair{ci, si, days, diff})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if a.days != b.days {
+			return a.days < b.days
+		}
+		if a.diff != b.diff {
+			return a.diff < b.diff
+		}
+		sa, sb := settlements[a.s], settlements[b.s]
+		if !sa.Time.Equal(sb.Time) {
+			return sa.Time.Before(sb.Time)
+		}
+		if sa.TransactionID != sb.TransactionID {
+			return sa.TransactionID < sb.TransactionID
+		}
+		ca, cb := charges[a.c], charges[b.c]
+		if ca.Date != cb.Date {
+			return ca.Date < cb.Date
+		}
+		return ca.TransactionID < cb.TransactionID
+	})
+
+	usedCharge := make([]bool, len(charges))
+	usedSettlement := make([]bool, len(settlements))
+	var matches []ReconcileMatch
+	for _, p := range pairs {
+		if usedCharge[p.c] || usedSettlement[p.s] {
+			continue
+		}
+		usedCharge[p.c], usedSettlement[p.s] = true, true
+		c, s := charges[p.c], settlements[p.s]
+		matches = append(matches, ReconcileMatch{Settlement: s, Charges: []ReconcileCharge{c}, Difference: c.Amount - s.Amount, DaysApart: p.days})
+	}
+	if opts.MaxGroup < 2 {
+		return matches
+	}
+
+	// 多笔账单合并为一笔清算
+	order := make([]int, 0, len(settlements))
+	for si := range settlements {
+		if !usedSettlement[si] {
+			order = append(order, si)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := settlements[order[i]], settlements[order[j]]
+		if !a.Time.Equal(b.Time) {
+			return a.Time.Before(b.Time)
+		}
+		return a.TransactionID < b.TransactionID
+	})
+	for _, si := range order {
+		s := settlements[si]
+		type candidate struct {
+			c    int
+			days int
+		}
+		var candidates []candidate
+		for ci, c := range charges {
+			if usedCharge[ci] || c.Amount > s.Amount+opts.Tolerance {
+				continue
+			}
+			if days := daysApart(c, s); days >= 0 && days <= opts.WindowDays {
+				candidates = append(candidates, candidate{ci, days})
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			a, b := candidates[i], candidates[j]
+			if a.days != b.days {
+				return a.days < b.days
+			}
+			return charges[a.c].TransactionID < charges[b.c].TransactionID
+		})
+		if len(candidates) > maxGroupCandidates {
+			candidates = candidates[:maxGroupCandidates]
+		}
+
+		var best []int
+		bestDays, bestDiff := 0, Money(0)
+		var pick []int
+		var search func(start int, sum Money, days int)
+		search = func(start int, sum Money, days int) {
+			if len(pick) >= 2 {
+				diff := (sum - s.Amount).Abs()
+				if diff <= opts.Tolerance && (best == nil || days < bestDays ||
+					days == bestDays && (diff < bestDiff || diff == bestDiff && len(pick) < len(best))) {
+					best = append([]int(nil), pick...)
+					bestDays, bestDiff = days, diff
+				}
+			}
+			if len(pick) == opts.MaxGroup {
+				return
+			}
+			for i := start; i < len(candidates); i++ {
+				c := candidates[i]
+				next := sum + charges[c.c].Amount
+				if next > s.Amount+opts.Tolerance {
+					continue
+				}
+				pick = append(pick, c.c)
+				d := days
+				if c.days > d {
+					d = c.days
+				}
+				search(i+1, next, d)
+				pick = pick[:len(pick)-1]
+			}
+		}
+		search(0, 0, 0)
+		if best == nil {
+			continue
+		}
+		match := ReconcileMatch{Settlement: s, DaysApart: bestDays}
+		for _, ci := range best {
+			usedCharge[ci] = true
+			match.Charges = append(match.Charges, charges[ci])
+		}
+		sort.Slice(match.Charges, func(i, j int) bool {
+			if match.Charges[i].Date != match.Charges[j].Date {
+				return match.Charges[i].Date < match.Charges[j].Date
+			}
+			return match.Charges[i].TransactionID < match.Charges[j].TransactionID
+		})
+		match.Difference = match.chargeSum() - s.Amount
+		usedSettlement[si] = true
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+// reconcileKey 一个广告账户的一张卡
+type reconcileKey struct{ Account, Card string }
+
+// scopeFunc 附加的查询条件
+type scopeFunc func(*gorm.DB) *gorm.DB
+
+// unmatchedForKeys 查询 keys 中尚未核对的账单和交易清算，chargeScope、settlementScope 不为 nil 时附加到各自的查询。
+// 已结账期间的账单和交易清算不再核对
+func unmatchedForKeys(tx *gorm.DB, keys []reconcileKey, chargeScope scopeFunc, settlementScope scopeFunc) ([]ReconcileCharge, []ReconcileSettlement, error) {
+	locks, err := loadPeriodLocks(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var charges []ReconcileCharge
+	var settlements []ReconcileSettlement
+	const batch = 500
+	for start := 0; start < len(keys); start += batch {
+		end := start + batch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		tuples := make([][]interface{}, 0, end-start)
+		for _, k := range keys[start:end] {
+			tuples = append(tuples, []interface{}{k.Account, k.Card})
+		}
+
+		var records []TransactionRecord
+		query := tx.Select("transaction_id, account, payment_method, date, platform, amount").
+			Where("is_trading_authorization = ?", false).
+			Where("(account, payment_method) IN ?", tuples)
+		if chargeScope != nil {
+			query = chargeScope(query)
+		}
+		if err := query.Find(&records).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, r := range records {
+			if locks.checkRecord(r.Account, r.Date) != nil {
+				continue
+			}
+			charges = append(charges, ReconcileCharge{TransactionID: r.TransactionID, Account: r.Account, Card: r.PaymentMethod,
+				Date: r.Date, Platform: r.Platform, Amount: r.Amount.Abs()})
+		}
+
+		var found []Transaction
+		query = tx.Select("transaction_id, nickname, card_number, transaction_time, order_amount").
+			Where("transaction_type = ? AND is_judge = ?", TypeSettlement, false).
+			Where("(nickname, card_number) IN ?", tuples)
+		if settlementScope != nil {
+			query = settlementScope(query)
+		}
+		if err := query.Find(&found).Error; err != nil {
+			return nil, nil, err
+		}
+		for _, t := range found {
+			if locks.checkTransaction(t.Nickname, t.TransactionTime) != nil {
+				continue
+			}
+			settlements = append(settlements, ReconcileSettlement{TransactionID: t.TransactionID, Account: t.Nickname, Card: t.CardNumber,
+				Time: t.TransactionTime, Amount: t.OrderAmount.Abs()})
+		}
+	}
+	return charges, settlements, nil
+}
+
+// reconcileImported 导入账单后对涉及的账户和卡重新核对，匹配结果记在导入批次下，回滚批次时一并删除。
+// 返回每个账户新匹配的账单数
+func reconcileImported(tx *gorm.DB, batch *ImportBatch, keys []reconcileKey) (map[string]int, error) {
+	charges, settlements, err := unmatchedForKeys(tx, keys, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := reconcileAndSave(tx, charges, settlements, batch.ID, batch.Uploader)
+	if err != nil {
+		return nil, err
+	}
+	matched := map[string]int{}
+	for _, r := range rows {
+		matched[r.Account]++
+	}
+	return matched, nil
+}
+
+// reconcileAndSave 排除已撤销的组合后核对并保存匹配，返回新增的匹配
+func reconcileAndSave(tx *gorm.DB, charges []ReconcileCharge, settlements []ReconcileSettlement, batchID uint, user string) ([]Reconciliation, error) {
+	if len(charges) == 0 || len(settlements) == 0 {
+		return nil, nil
+	}
+	opts := DefaultReconcileOptions()
+	recordIDs := make([]string, 0, len(charges))
+	for _, c := range charges {
+		recordIDs = append(recordIDs, c.TransactionID)
+	}
+	var err error
+	if opts.Exclude, err = revokedPairs(tx, recordIDs); err != nil {
+		return nil, err
+	}
+	return saveMatches(tx, Reconcile(charges, settlements, opts), batchID, user)
+}
+
+// reconcileKeys 账单行涉及的账户和卡，去重后按固定顺序排列
+func reconcileKeys(rows []TransactionRecord) []reconcileKey {
+	seen := map[reconcileKey]bool{}
+	var keys []reconcileKey
+	for _, r := range rows {
+		k := reconcileKey{r.Account, r.PaymentMethod}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Account != keys[j].Account {
+			return keys[i].Account < keys[j].Account
+		}
+		return keys[i].Card < keys[j].Card
+	})
+	return keys
+}
+
+// reconcileMu 串行化账单导入和重新核对，避免同一笔账单被两边同时匹配
+var reconcileMu sync.Mutex
+
+// AccountMatchCount 一个账户新匹配的账单数
+type AccountMatchCount struct {
+	Account string `json:"account"`
+	Matched int    `json:"matched"`
+}
+
+// ReconcileSummary 一次重新核对的结果
+type ReconcileSummary struct {
+	Account              string              `json:"account"`               // 为空表示所有账户
+	UnmatchedRecords     int                 `json:"unmatched_records"`     // 参与核对的未核对账单数
+	UnmatchedSettlements int                 `json:"unmatched_settlements"` // 参与核对的未核对交易清算数
+	MatchedRecords       int                 `json:"matched_records"`       // 新匹配的账单数
+	MatchedSettlements   int                 `json:"matched_settlements"`   // 新匹配的交易清算数
+	Accounts             []AccountMatchCount `json:"accounts"`              // 按账户统计的新匹配账单数
+	Matches              []Reconciliation    `json:"matches"`               // 新增的匹配
+}
+
+// ReconcileUnmatched 对未核对的账单和交易清算重新核对，用于账单先于虚拟卡文件导入的情况。
+// account 为空时核对所有账户；start_time、end_time 按 loc 中的日期限制账单日期，
+// 交易清算的时间范围在此基础上前后各放宽 WindowDays 天
+func ReconcileUnmatched(account string, startTime int, endTime int, loc *time.Location, user string) (*ReconcileSummary, error) {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	summary := &ReconcileSummary{Account: account, Accounts: []AccountMatchCount{}, Matches: []Reconciliation{}}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var chargeScope, settlementScope scopeFunc
+		if from, to, ok := dayRange(startTime, endTime, loc); ok {
+			window := DefaultReconcileOptions().WindowDays
+			chargeScope = func(q *gorm.DB) *gorm.DB { return whereRecordDate(q, startTime, endTime, loc) }
+			settlementScope = func(q *gorm.DB) *gorm.DB {
+				return q.Where("transaction_time >= ? AND transaction_time < ?", from.AddDate(0, 0, -window), to.AddDate(0, 0, window))
+			}
+		}
+
+		keyQuery := tx.Model(&TransactionRecord{}).Distinct("account", "payment_method").
+			Where("is_trading_authorization = ?", false)
+		if account != "" {
+			keyQuery = keyQuery.Where("account = ?", account)
+		}
+		if chargeScope != nil {
+			keyQuery = chargeScope(keyQuery)
+		}
+		var found []TransactionRecord
+		if err := keyQuery.Find(&found).Error; err != nil {
+			return err
+		}
+		charges, settlements, err := unmatchedForKeys(tx, reconcileKeys(found), chargeScope, settlementScope)
+		if err != nil {
+			return err
+		}
+		summary.UnmatchedRecords = len(charges)
+		summary.UnmatchedSettlements = len(settlements)
+
+		rows, err := reconcileAndSave(tx, charges, settlements, 0, user)
+		if err != nil {
+			return err
+		}
+		settled := map[string]bool{}
+		counts := map[string]int{}
+		for _, r := range rows {
+			settled[r.SettlementID] = true
+			counts[r.Account]++
+		}
+		summary.Matches = append(summary.Matches, rows...)
+		summary.MatchedRecords = len(rows)
+		summary.MatchedSettlements = len(settled)
+		for a, n := range counts {
+			summary.Accounts = append(summary.Accounts, AccountMatchCount{Account: a, Matched: n})
+		}
+		sort.Slice(summary.Accounts, func(i, j int) bool { return summary.Accounts[i].Account < summary.Accounts[j].Account })
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// StartReconcileScheduler 每隔 ReconcileInterval 分钟重新核对最近 ReconcileLookbackDays 天的未核对账单，
+// 间隔为0时不启动
+func StartReconcileScheduler() {
+	if utils.ReconcileInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(utils.ReconcileInterval) * time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			end := time.Now()
+			start := end.AddDate(0, 0, -utils.ReconcileLookbackDays)
+			summary, err := ReconcileUnmatched("", int(start.Unix()), int(end.Unix()), defaultLocation(), "scheduler")
+			if err != nil {
+				log.Printf("Scheduled reconcile failed: %v\n", err)
+				continue
+			}
+			if summary.MatchedRecords > 0 {
+				log.Printf("Scheduled reconcile matched %d records to %d settlements\n", summary.MatchedRecords, summary.MatchedSettlements)
+			}
+		}
+	}()
+}
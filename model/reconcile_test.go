@@ -0,0 +1,333 @@
+package model
+
+import (
+	"app/utils"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// matchSummary 匹配结果的简写：清算编号:账单编号+账单编号/相差天数/差额
+func matchSummary(matches []ReconcileMatch) []string {
+	var out []string
+	for _, m := range matches {
+		ids := make([]string, len(m.Charges))
+		for i, c := range m.Charges {
+			ids[i] = c.TransactionID
+		}
+		out = append(out, fmt.Sprintf("%s:%s/%d/%v", m.Settlement.TransactionID, strings.Join(ids, "+"), m.DaysApart, m.Difference))
+	}
+	return out
+}
+
+func TestReconcile(t *testing.T) {
+	savedSources := utils.SourceTimezones
+	utils.SourceTimezones = map[string]string{"FB": "UTC"}
+	t.Cleanup(func() { utils.SourceTimezones = savedSources })
+
+	charge := func(id, card, date string, amount Money) ReconcileCharge {
+		return ReconcileCharge{TransactionID: id, Account: "A", Card: card, Date: Date(date), Platform: "FB", Amount: amount}
+	}
+	settlement := func(id, card, at string, amount Money) ReconcileSettlement {
+		tm, err := time.Parse("2006-01-02 15:04", at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ReconcileSettlement{TransactionID: id, Account: "A", Card: card, Time: tm, Amount: amount}
+	}
+	oneToOne := ReconcileOptions{WindowDays: 3, MaxGroup: 1}
+	grouped := ReconcileOptions{WindowDays: 3, MaxGroup: 3}
+
+	tests := []struct {
+		name        string
+		charges     []ReconcileCharge
+		settlements []ReconcileSettlement
+		opts        ReconcileOptions
+		want        []string
+	}{
+		{
+			name:        "窗口内一对一",
+			charges:     []ReconcileCharge{charge("c1", "1234", "2024-04-01", 10000)},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-02 10:00", 10000)},
+			opts:        oneToOne,
+			want:        []string{"s1:c1/1/0.00"},
+		},
+		{
+			name:        "超出日期窗口",
+			charges:     []ReconcileCharge{charge("c1", "1234", "2024-04-01", 10000)},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-05 00:00", 10000)},
+			opts:        oneToOne,
+		},
+		{
+			name:        "清算在账单日期之前也按天数计算",
+			charges:     []ReconcileCharge{charge("c1", "1234", "2024-04-05", 10000)},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-02 23:59", 10000)},
+			opts:        oneToOne,
+			want:        []string{"s1:c1/3/0.00"},
+		},
+		{
+			name:        "差额在容差之内",
+			charges:     []ReconcileCharge{charge("c1", "1234", "2024-04-01", 10005)},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        ReconcileOptions{Tolerance: 10, WindowDays: 3, MaxGroup: 1},
+			want:        []string{"s1:c1/0/0.05"},
+		},
+		{
+			name:        "差额超过容差",
+			charges:     []ReconcileCharge{charge("c1", "1234", "2024-04-01", 10005)},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        oneToOne,
+		},
+		{
+			name:        "不同的卡不匹配",
+			charges:     []ReconcileCharge{charge("c1", "1234", "2024-04-01", 10000)},
+			settlements: []ReconcileSettlement{settlement("s1", "5678", "2024-04-01 08:00", 10000)},
+			opts:        oneToOne,
+		},
+		{
+			name:        "账单日期无效时不匹配",
+			charges:     []ReconcileCharge{charge("c1", "1234", "04/01/2024", 10000)},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        oneToOne,
+		},
+		{
+			name: "优先日期最近的账单",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 10000),
+				charge("c2", "1234", "2024-04-03", 10000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-03 08:00", 10000)},
+			opts:        oneToOne,
+			want:        []string{"s1:c2/0/0.00"},
+		},
+		{
+			name: "日期相同时优先差额最小的账单",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 10050),
+				charge("c2", "1234", "2024-04-01", 9990),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        ReconcileOptions{Tolerance: 100, WindowDays: 3, MaxGroup: 1},
+			want:        []string{"s1:c2/0/-0.10"},
+		},
+		{
+			name:    "日期和差额都相同时优先较早的清算",
+			charges: []ReconcileCharge{charge("c1", "1234", "2024-04-01", 10000)},
+			settlements: []ReconcileSettlement{
+				settlement("s2", "1234", "2024-04-01 09:00", 10000),
+				settlement("s1", "1234", "2024-04-01 01:00", 10000),
+			},
+			opts: oneToOne,
+			want: []string{"s1:c1/0/0.00"},
+		},
+		{
+			name: "每笔账单只使用一次，次优的账单留给另一笔清算",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 10000),
+				charge("c2", "1234", "2024-04-02", 10000),
+			},
+			settlements: []ReconcileSettlement{
+				settlement("s1", "1234", "2024-04-01 08:00", 10000),
+				settlement("s2", "1234", "2024-04-01 09:00", 10000),
+			},
+			opts: oneToOne,
+			want: []string{"s1:c1/0/0.00", "s2:c2/1/0.00"},
+		},
+		{
+			name: "被撤销的匹配不再使用",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 10000),
+				charge("c2", "1234", "2024-04-03", 10000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        ReconcileOptions{WindowDays: 3, MaxGroup: 1, Exclude: map[ReconcilePair]bool{{"c1", "s1"}: true}},
+			want:        []string{"s1:c2/2/0.00"},
+		},
+		{
+			name: "只做一对一时不合并账单",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 6000),
+				charge("c2", "1234", "2024-04-02", 4000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-02 08:00", 10000)},
+			opts:        oneToOne,
+		},
+		{
+			name: "多笔账单合并匹配一笔清算，账单按日期排列",
+			charges: []ReconcileCharge{
+				charge("c2", "1234", "2024-04-02", 4000),
+				charge("c1", "1234", "2024-04-01", 6000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-02 08:00", 10000)},
+			opts:        grouped,
+			want:        []string{"s1:c1+c2/1/0.00"},
+		},
+		{
+			name: "一对一优先于合并",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 6000),
+				charge("c2", "1234", "2024-04-01", 4000),
+				charge("c3", "1234", "2024-04-03", 10000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        grouped,
+			want:        []string{"s1:c3/2/0.00"},
+		},
+		{
+			name: "合并时优先日期最近的组合",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 6000),
+				charge("c2", "1234", "2024-04-01", 4000),
+				charge("c3", "1234", "2024-04-03", 6000),
+				charge("c4", "1234", "2024-04-03", 4000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-03 08:00", 10000)},
+			opts:        grouped,
+			want:        []string{"s1:c3+c4/0/0.00"},
+		},
+		{
+			name: "日期相同时优先差额最小的组合",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 6000),
+				charge("c2", "1234", "2024-04-01", 3990),
+				charge("c3", "1234", "2024-04-01", 4000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        ReconcileOptions{Tolerance: 50, WindowDays: 3, MaxGroup: 2},
+			want:        []string{"s1:c1+c3/0/0.00"},
+		},
+		{
+			name: "日期和差额都相同时优先笔数少的组合",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 5000),
+				charge("c2", "1234", "2024-04-01", 5000),
+				charge("c3", "1234", "2024-04-01", 2000),
+				charge("c4", "1234", "2024-04-01", 3000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        grouped,
+			want:        []string{"s1:c1+c2/0/0.00"},
+		},
+		{
+			name: "合并笔数不超过 MaxGroup",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 3000),
+				charge("c2", "1234", "2024-04-01", 3000),
+				charge("c3", "1234", "2024-04-01", 4000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        ReconcileOptions{WindowDays: 3, MaxGroup: 2},
+		},
+		{
+			name: "合并时也排除被撤销的匹配",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 6000),
+				charge("c2", "1234", "2024-04-01", 4000),
+			},
+			settlements: []ReconcileSettlement{settlement("s1", "1234", "2024-04-01 08:00", 10000)},
+			opts:        ReconcileOptions{WindowDays: 3, MaxGroup: 3, Exclude: map[ReconcilePair]bool{{"c2", "s1"}: true}},
+		},
+		{
+			name: "结果按清算时间排列",
+			charges: []ReconcileCharge{
+				charge("c1", "1234", "2024-04-01", 10000),
+				charge("c2", "5678", "2024-04-01", 2000),
+			},
+			settlements: []ReconcileSettlement{
+				settlement("s2", "1234", "2024-04-01 09:00", 10000),
+				settlement("s1", "5678", "2024-04-01 01:00", 2000),
+			},
+			opts: oneToOne,
+			want: []string{"s1:c2/0/0.00", "s2:c1/0/0.00"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchSummary(Reconcile(tt.charges, tt.settlements, tt.opts))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Reconcile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReconcileUsesPlatformTimezone(t *testing.T) {
+	savedSources := utils.SourceTimezones
+	utils.SourceTimezones = map[string]string{"FB": "America/Los_Angeles"}
+	t.Cleanup(func() { utils.SourceTimezones = savedSources })
+
+	// 2024-04-02 03:00 UTC 在洛杉矶是 4 月 1 日
+	charges := []ReconcileCharge{{TransactionID: "c1", Account: "A", Card: "1234", Date: "2024-04-01", Platform: "FB", Amount: 10000}}
+	settlements := []ReconcileSettlement{{TransactionID: "s1", Account: "A", Card: "1234", Time: time.Date(2024, 4, 2, 3, 0, 0, 0, time.UTC), Amount: 10000}}
+	got := matchSummary(Reconcile(charges, settlements, ReconcileOptions{MaxGroup: 1}))
+	if want := []string{"s1:c1/0/0.00"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Reconcile() = %v, want %v", got, want)
+	}
+}
+
+func TestGroupDifference(t *testing.T) {
+	savedTimezone := utils.Timezone
+	utils.Timezone = "UTC"
+	t.Cleanup(func() { utils.Timezone = savedTimezone })
+
+	settlement := Transaction{TransactionTime: time.Date(2024, 4, 3, 10, 0, 0, 0, time.UTC), OrderAmount: -10000}
+	records := []TransactionRecord{
+		{Date: "2024-04-01", Amount: 6000},
+		{Date: "2024-04-02", Amount: -4500},
+		{Date: "bad", Amount: 100},
+	}
+	difference, days := groupDifference(settlement, records)
+	if difference != 600 || days != 2 {
+		t.Errorf("groupDifference() = %v, %d, want 6.00, 2", difference, days)
+	}
+}
+
+// TestUnmatchUpdatesGroupDifference 多笔账单合并的匹配撤销其中一笔后，其余匹配的差额按剩下的账单计算，需要设置 WB_BENCH_DSN
+func TestUnmatchUpdatesGroupDifference(t *testing.T) {
+	openBenchDB(t, &Transaction{}, &TransactionRecord{}, &Reconciliation{}, &PeriodLock{})
+	savedTimezone := utils.Timezone
+	utils.Timezone = "UTC"
+	t.Cleanup(func() { utils.Timezone = savedTimezone })
+
+	cleanup := func() {
+		db.Where("transaction_id = ?", "UNMATCH-S").Delete(&Transaction{})
+		db.Where("transaction_id IN ?", []string{"UNMATCH-R1", "UNMATCH-R2"}).Delete(&TransactionRecord{})
+		db.Where("settlement_id = ?", "UNMATCH-S").Delete(&Reconciliation{})
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	if err := db.Create(&Transaction{
+		TransactionID:   "UNMATCH-S",
+		TransactionType: TypeSettlement,
+		TransactionTime: time.Date(2024, 4, 3, 10, 0, 0, 0, time.UTC),
+		Nickname:        "UNMATCH",
+		OrderAmount:     -10000,
+		OrderCurrency:   "USD",
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&[]TransactionRecord{
+		{TransactionID: "UNMATCH-R1", Account: "UNMATCH", Date: "2024-04-01", Amount: 6000},
+		{TransactionID: "UNMATCH-R2", Account: "UNMATCH", Date: "2024-04-03", Amount: 4000},
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+	rows, err := MatchManually("UNMATCH-S", []string{"UNMATCH-R1", "UNMATCH-R2"}, "tester")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Unmatch(rows[0].ID, "tester"); err != nil {
+		t.Fatal(err)
+	}
+
+	var remaining Reconciliation
+	if err := db.First(&remaining, rows[1].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if remaining.Difference != -6000 || remaining.DaysApart != 0 {
+		t.Errorf("remaining match: difference %v, days %d, want -60.00, 0", remaining.Difference, remaining.DaysApart)
+	}
+}
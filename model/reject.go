@@ -0,0 +1,109 @@
+package model
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// ImportReject 导入批次中被拒绝或可疑的行
+type ImportReject struct {
+	ID      uint     `gorm:"primaryKey" json:"id"`
+	BatchID uint     `gorm:"index" json:"batch_id"`
+	Row     int      `gorm:"column:source_row;type:int" json:"row"`
+	Raw     []string `gorm:"serializer:json;type:text" json:"raw"`
+	Reason  string   `gorm:"type:varchar(500)" json:"reason"`
+	Warning bool     `gorm:"type:boolean" json:"warning"`
+}
+
+// saveImportRejects 保存批次的拒绝行明细
+func saveImportRejects(batchID uint, rows []RejectedRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	rejects := make([]ImportReject, 0, len(rows))
+	for _, r := range rows {
+		reason := r.Reason
+		if len([]rune(reason)) > 500 {
+			reason = string([]rune(reason)[:500])
+		}
+		rejects = append(rejects, ImportReject{
+			BatchID: batchID,
+			Row:     r.Row,
+			Raw:     r.Raw,
+			Reason:  reason,
+			Warning: r.Warning,
+		})
+	}
+	return db.CreateInBatches(&rejects, 500).Error
+}
+
+// GetImportRejects 按行号查询批次的拒绝行明细
+func GetImportRejects(batchID uint) ([]ImportReject, error) {
+	var rejects []ImportReject
+	err := db.Where("batch_id = ?", batchID).Order("source_row ASC, id ASC").Find(&rejects).Error
+	return rejects, err
+}
+
+// WriteRejectsXLSX 把批次的拒绝行导出为 XLSX 供核对：前面各列与源文件标题行一致，最后三列为原始行号、类型和原因。
+// 导出的文件不能代替源文件重新上传：全部写入模式下其余的行也没有写入，需要修正源文件后整个重新上传；
+// 账单的源文件是带账户区块和总计行的 CSV，也不能用这个 XLSX 上传。
+// 只有虚拟卡文件在 BestEffort 模式下导入时，可以只上传修正后的拒绝行（最后三列按表头忽略）
+func WriteRejectsXLSX(batchID uint, w io.Writer) error {
+	batch, err := GetImportBatch(batchID)
+	if err != nil {
+		return err
+	}
+	rejects, err := GetImportRejects(batchID)
+	if err != nil {
+		return err
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	const sheet = "Sheet1"
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return err
+	}
+
+	width := len(batch.Header)
+	for _, r := range rejects {
+		if len(r.Raw) > width {
+			width = len(r.Raw)
+		}
+	}
+	header := make([]interface{}, 0, width+3)
+	for i := 0; i < width; i++ {
+		if i < len(batch.Header) {
+			header = append(header, batch.Header[i])
+		} else {
+			header = append(header, fmt.Sprintf("列%d", i+1))
+		}
+	}
+	header = append(header, "原始行号", "类型", "原因")
+	if err := sw.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	for i, r := range rejects {
+		row := make([]interface{}, width+3)
+		for j, v := range r.Raw {
+			row[j] = v
+		}
+		kind := "拒绝"
+		if r.Warning {
+			kind = "可疑"
+		}
+		row[width], row[width+1], row[width+2] = r.Row, kind, r.Reason
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := sw.SetRow(cell, row); err != nil {
+			return err
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	return f.Write(w)
+}
@@ -0,0 +1,215 @@
+package model
+
+import (
+	"app/utils"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TransactionTimeLayout 虚拟卡文件中交易时间的格式
+const TransactionTimeLayout = "2006-01-02 15:04:05"
+
+// DateLayout 日期的格式
+const DateLayout = "2006-01-02"
+
+// Date 不含时区的日历日期，对应数据库中的 DATE 列，JSON 中为 "2006-01-02"。
+// 账单日期是广告平台按自己的时区划分的日期，不能换算成某个时刻
+type Date string
+
+// Value 空日期写入 NULL
+func (d Date) Value() (driver.Value, error) {
+	if d == "" {
+		return nil, nil
+	}
+	return string(d), nil
+}
+
+// Scan 读取 DATE 列，parseTime=True 时驱动返回 time.Time
+func (d *Date) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*d = ""
+	case time.Time:
+		*d = Date(v.Format(DateLayout))
+	case []byte:
+		*d = Date(firstN(string(v), len(DateLayout)))
+	case string:
+		*d = Date(firstN(v, len(DateLayout)))
+	default:
+		return fmt.Errorf("cannot scan %T into Date", src)
+	}
+	return nil
+}
+
+func firstN(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// defaultLocation 配置的默认时区，配置无效时为服务器时区
+func defaultLocation() *time.Location {
+	loc, err := time.LoadLocation(utils.Timezone)
+	if err != nil {
+		log.Printf("Invalid default timezone %q, using server timezone: %v\n", utils.Timezone, err)
+		return time.Local
+	}
+	return loc
+}
+
+// SourceLocation 虚拟卡供应商或广告平台导出文件中的时间所用的时区，未单独配置时为默认时区
+func SourceLocation(source string) *time.Location {
+	name, ok := utils.SourceTimezones[source]
+	if !ok || name == "" {
+		return defaultLocation()
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Invalid timezone %q for %s, using default timezone: %v\n", name, source, err)
+		return defaultLocation()
+	}
+	return loc
+}
+
+// UserLocation 接口传入的用户时区（IANA 名称，如 Asia/Shanghai），为空时为默认时区
+func UserLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return defaultLocation(), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("无效的时区: %q", name)
+	}
+	return loc, nil
+}
+
+// dayRange start_time、end_time（Unix 秒）在 loc 中所在日期的边界，
+// 返回 [开始日 0 点, 结束日次日 0 点)；任一为0时不限制时间，ok 为 false
+func dayRange(startTime int, endTime int, loc *time.Location) (from time.Time, to time.Time, ok bool) {
+	if startTime == 0 || endTime == 0 {
+		return from, to, false
+	}
+	start := time.Unix(int64(startTime), 0).In(loc)
+	end := time.Unix(int64(endTime), 0).In(loc)
+	from = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	to = time.Date(end.Year(), end.Month(), end.Day()+1, 0, 0, 0, 0, loc)
+	return from, to, true
+}
+
+// whereTransactionTime 按 loc 中的日期边界筛选交易时间
+func whereTransactionTime(query *gorm.DB, startTime int, endTime int, loc *time.Location) *gorm.DB {
+	from, to, ok := dayRange(startTime, endTime, loc)
+	if !ok {
+		return query
+	}
+	return query.Where("transaction_time >= ? AND transaction_time < ?", from, to)
+}
+
+// whereRecordDate 按 loc 中的日期边界筛选账单日期。账单日期是平台时区的日期，
+// 先把边界换算为各平台时区中的日期再比较，没有单独配置时区的平台按默认时区。
+// 与 dayRange 一样取左闭右开的日期范围：边界所在的那天归入后一个范围，相邻的范围不会重复计入
+func whereRecordDate(query *gorm.DB, startTime int, endTime int, loc *time.Location) *gorm.DB {
+	from, to, ok := dayRange(startTime, endTime, loc)
+	if !ok {
+		return query
+	}
+	dates := func(src *time.Location) []interface{} {
+		return []interface{}{from.In(src).Format(DateLayout), to.In(src).Format(DateLayout)}
+	}
+
+	platforms := make([]string, 0, len(utils.SourceTimezones))
+	for name := range utils.SourceTimezones {
+		platforms = append(platforms, name)
+	}
+	sort.Strings(platforms)
+	var conds []string
+	var args []interface{}
+	for _, name := range platforms {
+		conds = append(conds, "(platform = ? AND date >= ? AND date < ?)")
+		args = append(append(args, name), dates(SourceLocation(name))...)
+	}
+	if len(platforms) == 0 {
+		return query.Where("date >= ? AND date < ?", dates(defaultLocation())...)
+	}
+	conds = append(conds, "(platform NOT IN ? AND date >= ? AND date < ?)")
+	args = append(append(args, platforms), dates(defaultLocation())...)
+	return query.Where("("+strings.Join(conds, " OR ")+")", args...)
+}
+
+// migrateTimeColumns 把旧版以字符串保存的交易时间和账单日期整理为可以转换为 DATETIME / DATE 的值，
+// 在 AutoMigrate 修改列类型之前执行。交易时间按供应商时区解析后以数据库连接的时区写回，
+// 无法解析的值置为 NULL
+func migrateTimeColumns() error {
+	if isCharColumn(&Transaction{}, "transaction_time") {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			hasProvider := tx.Migrator().HasColumn(&Transaction{}, "provider")
+			type legacyTime struct {
+				TransactionID   string
+				TransactionTime *string
+				Provider        string
+			}
+			columns := "transaction_id, transaction_time"
+			if hasProvider {
+				columns += ", provider"
+			}
+			var rows []legacyTime
+			if err := tx.Table("transaction").Select(columns).Find(&rows).Error; err != nil {
+				return err
+			}
+			for _, row := range rows {
+				provider := row.Provider
+				if provider == "" {
+					provider = DefaultCardProvider
+				}
+				var value interface{}
+				if row.TransactionTime != nil {
+					t, err := time.ParseInLocation(TransactionTimeLayout, strings.TrimSpace(*row.TransactionTime), SourceLocation(provider))
+					if err == nil {
+						value = t.In(time.Local).Format(TransactionTimeLayout)
+					}
+				}
+				if err := tx.Table("transaction").Where("transaction_id = ?", row.TransactionID).
+					Update("transaction_time", value).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("迁移交易时间失败: %w", err)
+		}
+	}
+	if isCharColumn(&TransactionRecord{}, "date") {
+		err := db.Table("transaction_record").
+			Where("date IS NOT NULL AND date NOT REGEXP ?", "^[0-9]{4}-[0-9]{2}-[0-9]{2}$").
+			Update("date", nil).Error
+		if err != nil {
+			return fmt.Errorf("迁移账单日期失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// isCharColumn 表中的列是否仍是字符串类型
+func isCharColumn(model interface{}, column string) bool {
+	if !db.Migrator().HasTable(model) {
+		return false
+	}
+	types, err := db.Migrator().ColumnTypes(model)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if t.Name() == column {
+			return strings.Contains(strings.ToUpper(t.DatabaseTypeName()), "CHAR")
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"app/utils"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// dryRunDB 只生成 SQL 不执行的连接，用于检查查询条件
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	conn, err := sql.Open("mysql", "test:test@tcp(127.0.0.1:1)/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	d, err := gorm.Open(mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: true}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Default.LogMode(logger.Silent),
+		NamingStrategy:       schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("timezone data for %s not available: %v", name, err)
+	}
+	return loc
+}
+
+func TestDayRange(t *testing.T) {
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+	// 2024-04-01 00:30 与 2024-04-30 23:59 上海时间
+	start := int(time.Date(2024, 4, 1, 0, 30, 0, 0, shanghai).Unix())
+	end := int(time.Date(2024, 4, 30, 23, 59, 0, 0, shanghai).Unix())
+	tests := []struct {
+		name       string
+		start, end int
+		loc        *time.Location
+		wantOK     bool
+		from, to   time.Time
+	}{
+		{"不限制时间", 0, end, shanghai, false, time.Time{}, time.Time{}},
+		{"按上海日期取整到整天", start, end, shanghai, true,
+			time.Date(2024, 4, 1, 0, 0, 0, 0, shanghai), time.Date(2024, 5, 1, 0, 0, 0, 0, shanghai)},
+		{"同一时刻在 UTC 中是前一天", start, end, time.UTC, true,
+			time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{"开始和结束在同一天", start, start, shanghai, true,
+			time.Date(2024, 4, 1, 0, 0, 0, 0, shanghai), time.Date(2024, 4, 2, 0, 0, 0, 0, shanghai)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to, ok := dayRange(tt.start, tt.end, tt.loc)
+			if ok != tt.wantOK || !from.Equal(tt.from) || !to.Equal(tt.to) {
+				t.Errorf("dayRange() = %v, %v, %v; want %v, %v, %v", from, to, ok, tt.from, tt.to, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestWhereRecordDateAdjacentMonths 平台与用户时区不同时，相邻两个月的账单日期范围首尾相接、不重叠
+func TestWhereRecordDateAdjacentMonths(t *testing.T) {
+	shanghai := mustLoadLocation(t, "Asia/Shanghai")
+	mustLoadLocation(t, "America/Los_Angeles")
+	savedSources, savedDefault := utils.SourceTimezones, utils.Timezone
+	utils.SourceTimezones = map[string]string{DefaultBillingPlatform: "America/Los_Angeles"}
+	utils.Timezone = "Asia/Shanghai"
+	t.Cleanup(func() { utils.SourceTimezones, utils.Timezone = savedSources, savedDefault })
+
+	d := dryRunDB(t)
+	month := func(m time.Month) []interface{} {
+		start := time.Date(2024, m, 1, 0, 0, 0, 0, shanghai)
+		end := start.AddDate(0, 1, 0).Add(-time.Second)
+		var records []TransactionRecord
+		stmt := whereRecordDate(d.Model(&TransactionRecord{}), int(start.Unix()), int(end.Unix()), shanghai).
+			Find(&records).Statement
+		if sql := stmt.SQL.String(); strings.Contains(sql, "BETWEEN") || !strings.Contains(sql, "date < ?") {
+			t.Errorf("want half-open date ranges, got %s", sql)
+		}
+		return stmt.Vars
+	}
+	// 参数依次为 平台, 起, 止, 其他平台列表, 起, 止
+	april, may := month(time.April), month(time.May)
+	if april[1] != "2024-03-31" || april[2] != "2024-04-30" {
+		t.Errorf("April on %s = [%v, %v), want [2024-03-31, 2024-04-30)", DefaultBillingPlatform, april[1], april[2])
+	}
+	if april[2] != may[1] {
+		t.Errorf("April ends at %v but May starts at %v", april[2], may[1])
+	}
+	if april[4] != "2024-04-01" || april[5] != "2024-05-01" || april[5] != may[4] {
+		t.Errorf("default timezone ranges: April [%v, %v), May [%v, %v)", april[4], april[5], may[4], may[5])
+	}
+}
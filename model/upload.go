@@ -1,51 +1,53 @@
 package model
 
 import (
-	"encoding/csv"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"os"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
-	"github.com/xuri/excelize/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type TransactionRecord struct {
-	Account                string  `gorm:"type:varchar(20)" json:"account"`
-	Date                   string  `gorm:"type:varchar(20)" json:"date"`
-	TransactionID          string  `gorm:"type:varchar(100);primaryKey" json:"transaction_id"` // 假设Transaction ID是唯一的，可以用作主键
-	PaymentMethod          string  `gorm:"type:varchar(100)" json:"payment_method"`
-	Amount                 float64 `gorm:"type:decimal(10,2)" json:"amount"` // 假设金额是浮点数，可以调整精度
-	Currency               string  `gorm:"type:varchar(10)" json:"currency"`
-	IsTicked               bool    `gorm:"type:boolean" json:"is_ticked"`                // 假设这是一个布尔字段，表示交易是否授权
-	IsTradingAuthorization bool    `gorm:"type:boolean" json:"is_trading_authorization"` // 假设这是一个布尔字段，表示交易是否授权
-	Note                   string  `gorm:"type:varchar(500)" json:"note"`
+	Account                string `gorm:"type:varchar(20)" json:"account"`
+	Date                   Date   `gorm:"type:date;index" json:"date"`                        // 平台时区中的账单日期
+	TransactionID          string `gorm:"type:varchar(100);primaryKey" json:"transaction_id"` // 假设Transaction ID是唯一的，可以用作主键
+	PaymentMethod          string `gorm:"type:varchar(100)" json:"payment_method"`
+	Amount                 Money  `gorm:"type:decimal(10,2)" json:"amount"`
+	Currency               string `gorm:"type:varchar(10)" json:"currency"`
+	IsTicked               bool   `gorm:"type:boolean" json:"is_ticked"`                // 假设这是一个布尔字段，表示交易是否授权
+	IsTradingAuthorization bool   `gorm:"type:boolean" json:"is_trading_authorization"` // 假设这是一个布尔字段，表示交易是否授权
+	Note                   string `gorm:"type:varchar(500)" json:"note"`
+	Platform               string `gorm:"type:varchar(20);default:facebook;index" json:"platform"` // 广告平台
+	BatchID                uint   `gorm:"index" json:"batch_id"`                                   // 导入批次
+	SourceRow              int    `gorm:"type:int" json:"source_row"`                              // 在源文件中的行号
 }
 
 type Transaction struct {
-	TransactionID       string  `gorm:"type:varchar(50);primaryKey" json:"transaction_id"`
-	TransactionTime     string  `gorm:"type:varchar(29)" json:"transaction_time"`
-	CardNumber          string  `gorm:"type:varchar(200);sensitive" json:"card_number"` // 假设卡号需要特殊处理
-	Nickname            string  `gorm:"type:varchar(100)" json:"nickname"`
-	BillName            string  `gorm:"type:varchar(255)" json:"bill_name"`
-	TransactionType     string  `gorm:"type:varchar(100)" json:"transaction_type"`
-	OrderAmount         float64 `gorm:"type:decimal(10,2)" json:"order_amount"` // 假设金额为小数
-	OrderCurrency       string  `gorm:"type:varchar(10)" json:"order_currency"`
-	TransactionAmount   float64 `gorm:"type:decimal(10,2)" json:"transaction_amount"`
-	TransactionFee      float64 `gorm:"type:decimal(10,2)" json:"transaction_fee"` // 假设费用也为小数
-	TransactionCurrency string  `gorm:"type:varchar(10)" json:"transaction_currency"`
-	TransactionStatus   string  `gorm:"type:varchar(100)" json:"transaction_status"`
-	AuthorizationCode   string  `gorm:"type:varchar(100)" json:"authorization_code,omitempty"` // 如果可能为空，使用omitempty
-	ResultCode          string  `gorm:"type:varchar(100)" json:"result_code"`
-	ResultDescription   string  `gorm:"type:varchar(255)" json:"result_description"`
-	SettlementStatus    string  `gorm:"type:varchar(100)" json:"settlement_status"`
-	IsJudge             bool    `gorm:"type:boolean" json:"is_judge"`
+	TransactionID       string    `gorm:"type:varchar(50);primaryKey" json:"transaction_id"`
+	TransactionTime     time.Time `gorm:"type:datetime;index" json:"transaction_time"`    // 按供应商时区解析后的时刻
+	CardNumber          string    `gorm:"type:varchar(200);sensitive" json:"card_number"` // 假设卡号需要特殊处理
+	Nickname            string    `gorm:"type:varchar(100)" json:"nickname"`
+	BillName            string    `gorm:"type:varchar(255)" json:"bill_name"`
+	TransactionType     string    `gorm:"type:varchar(100)" json:"transaction_type"`
+	OrderAmount         Money     `gorm:"type:decimal(10,2)" json:"order_amount"`
+	OrderCurrency       string    `gorm:"type:varchar(10)" json:"order_currency"`
+	TransactionAmount   Money     `gorm:"type:decimal(10,2)" json:"transaction_amount"`
+	TransactionFee      Money     `gorm:"type:decimal(10,2)" json:"transaction_fee"`
+	TransactionCurrency string    `gorm:"type:varchar(10)" json:"transaction_currency"`
+	TransactionStatus   string    `gorm:"type:varchar(100)" json:"transaction_status"`
+	AuthorizationCode   string    `gorm:"type:varchar(100)" json:"authorization_code,omitempty"` // 如果可能为空，使用omitempty
+	ResultCode          string    `gorm:"type:varchar(100)" json:"result_code"`
+	ResultDescription   string    `gorm:"type:varchar(255)" json:"result_description"`
+	SettlementStatus    string    `gorm:"type:varchar(100)" json:"settlement_status"`
+	IsJudge             bool      `gorm:"type:boolean" json:"is_judge"`
+	Provider            string    `gorm:"type:varchar(50);default:vcc;index" json:"provider"` // 虚拟卡供应商
+	BatchID             uint      `gorm:"index" json:"batch_id"`                              // 导入批次
+	SourceRow           int       `gorm:"type:int" json:"source_row"`                         // 在源文件中的行号
 }
 
 type ByTransactionTime []Transaction
@@ -53,208 +55,368 @@ type ByTransactionTime []Transaction
 func (a ByTransactionTime) Len() int      { return len(a) }
 func (a ByTransactionTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 func (a ByTransactionTime) Less(i, j int) bool {
-	t1, _ := time.Parse("2024-08-22 19:36:34", a[i].TransactionTime)
-	t2, _ := time.Parse("2024-08-22 19:36:34", a[j].TransactionTime)
-	return t1.Before(t2)
+	return a[i].TransactionTime.Before(a[j].TransactionTime)
 }
 
-func ImportTransactionsFromXLSX(filePath string) error {
-	f, err := excelize.OpenFile(filePath)
-	if err != nil {
-		return err
-	}
+// 统一的交易类型。各供应商的导出在导入时转换为这些类型，余额、消耗和核对都按它们计算
+const (
+	TypeOpenCard      = "开卡"
+	TypeTopUp         = "卡充值"
+	TypeAuthorization = "交易授权"
+	TypeSettlement    = "交易清算"
+	TypeRefund        = "交易退款"
+	TypeTopUpRefund   = "卡充退"
+	TypeAuthReversal  = "交易授权撤销"
+)
 
-	// 假设数据在第一张表的第二行开始（第一行为标题行）
-	sheetName := f.GetSheetName(0)
-	rows, err := f.GetRows(sheetName)
-	if err != nil {
-		return err
+var transactionTypes = []string{TypeOpenCard, TypeTopUp, TypeAuthorization, TypeSettlement, TypeRefund, TypeTopUpRefund, TypeAuthReversal}
+
+// IsTransactionType 是否为统一的交易类型之一
+func IsTransactionType(t string) bool {
+	for _, v := range transactionTypes {
+		if v == t {
+			return true
+		}
 	}
+	return false
+}
 
-	if len(rows) <= 1 { // 至少应该有一行数据
-		return errors.New("no data found in the Excel file")
+// ParseAmount 解析金额，允许千分位逗号
+func ParseAmount(s string) (Money, error) {
+	return ParseMoney(strings.ReplaceAll(s, ",", ""))
+}
+
+// LastFour 取卡号后四位，卡号不足四位时原样返回
+func LastFour(cardNumber string) string {
+	if len(cardNumber) < 4 {
+		return cardNumber
 	}
+	return cardNumber[len(cardNumber)-4:]
+}
 
-	// 跳过标题行
-	transactions := make([]Transaction, 0, len(rows)-1)
+// RejectedRow 导入时被拒绝或可疑的行：Raw 为该行的原始值，
+// Warning 为 true 表示该行已导入但数据可疑
+type RejectedRow struct {
+	Row     int      `json:"row"`
+	Raw     []string `json:"raw"`
+	Reason  string   `json:"reason"`
+	Warning bool     `json:"warning"`
+}
 
-	for _, row := range rows[1:] {
-		if len(row) < 14 { // 确保有足够的数据列
-			continue
-		}
+// ParsedTransaction 从虚拟卡文件中解析出的一行，Row 为文件中的行号（从1开始，含标题行）
+type ParsedTransaction struct {
+	Row int
+	Raw []string
+	Transaction
+	// Blank 源文件没有提供的字段（没有该列、单元格为空或无法解析），键为 Transaction 的字段名。
+	// 重新导入时这些字段保持已有的值
+	Blank map[string]bool
+}
 
-		// 解析行数据到 Transaction 结构
-		var trans Transaction
-		trans.TransactionID = row[0]
-		trans.TransactionTime = row[1]
-		trans.CardNumber = row[2][len(row[2])-4:]
-		trans.Nickname = row[3]
-		trans.BillName = row[4]
-		trans.TransactionType = row[5]
-		// 注意：这里可能需要处理浮点数和字符串的转换
-		trans.OrderAmount, _ = strconv.ParseFloat(row[6], 64)
-		trans.OrderCurrency = row[7]
-		trans.TransactionAmount, _ = strconv.ParseFloat(row[8], 64)
-		trans.TransactionFee, _ = strconv.ParseFloat(row[9], 64)
-		trans.TransactionCurrency = row[10]
-		trans.TransactionStatus = row[11]
-		trans.AuthorizationCode = row[12]
-		trans.ResultCode = row[13]
-		trans.ResultDescription = row[14]
-		trans.SettlementStatus = row[15]
-		// 如果还有更多字段，继续解析
-
-		var existing Transaction
-		db.Table("transaction").Where("transaction_id = ?", trans.TransactionID).First(&existing)
-		if existing.TransactionID != "" {
-			// 如果已存在，则跳过此条记录
-			continue
-		}
+// TransactionReader 逐行读取一个供应商导出的虚拟卡交易文件，各供应商的实现见 importer 包
+type TransactionReader interface {
+	// Header 源文件的标题行，导出拒绝行时原样写回
+	Header() []string
+	// Each 依次解析每个数据行：有效行交给 onRow，无法导入的行以及可疑行交给 onReject
+	// （可疑行同时也会交给 onRow）。onRow 返回错误时停止读取
+	Each(onRow func(ParsedTransaction) error, onReject func(RejectedRow)) error
+	Close() error
+}
 
-		transactions = append(transactions, trans)
-	}
+// RowEstimator 读取器可以选择实现的接口：在读取之前估计数据行数，用于汇报导入进度。
+// 估计值可以与实际行数不同，0 表示未知
+type RowEstimator interface {
+	EstimatedRows() int
+}
+
+// eachTransaction 在 reader.Each 之上拒绝文件内交易ID重复的行，与供应商无关
+func eachTransaction(reader TransactionReader, onRow func(ParsedTransaction) error, onReject func(RejectedRow)) error {
+	seen := make(map[string]int)
+	return reader.Each(func(p ParsedTransaction) error {
+		if first, ok := seen[p.TransactionID]; ok {
+			onReject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: fmt.Sprintf("交易ID与第%d行重复", first)})
+			return nil
+		}
+		seen[p.TransactionID] = p.Row
+		return onRow(p)
+	}, onReject)
+}
 
-	sort.Sort(ByTransactionTime(transactions))
+// importChunkSize 每批查重和写入的行数
+const importChunkSize = 1000
 
-	// 保存到数据库
-	for _, trans := range transactions {
-		result := db.Create(&trans)
+// insertTransactions 批量写入交易，主键已存在的行由 ON DUPLICATE KEY 忽略；
+// 整批写入失败时退回逐行写入，返回写入失败的行下标及错误
+func insertTransactions(tx *gorm.DB, chunk []Transaction) (inserted int, failed map[int]error) {
+	if len(chunk) == 0 {
+		return 0, nil
+	}
+	// MySQL 中失败的语句只回滚自身，不影响所在事务中已写入的行
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&chunk)
+	if result.Error == nil {
+		return int(result.RowsAffected), nil
+	}
+	log.Printf("Failed to save transactions in batch, retrying row by row: %v\n", result.Error)
+	failed = make(map[int]error)
+	for i := range chunk {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&chunk[i])
 		if result.Error != nil {
 			log.Printf("Failed to save transaction: %v\n", result.Error)
-			// 可以选择继续或中断处理，这里选择继续
-			// 如果需要中断，可以使用 return err
+			failed[i] = result.Error
+			continue
 		}
+		inserted += int(result.RowsAffected)
 	}
-	return nil
+	return inserted, failed
 }
 
-func parseDate(dateStr string) (string, error) {
-	const layout = "01/02/2006" // Go 的日期格式是固定的，这里是月/日/年
-	parsed, err := time.Parse(layout, dateStr)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse date: %w", err)
+// ImportTransactions 从供应商文件 filePath 的读取器导入虚拟卡交易，交易记录 opts.Provider 作为供应商；
+// 全部写入在一个事务中完成，见 ImportOptions.BestEffort
+func ImportTransactions(reader TransactionReader, filePath string, opts ImportOptions) (*ImportBatch, error) {
+	if opts.Provider == "" {
+		opts.Provider = DefaultCardProvider
 	}
-	return parsed.Format("2006-01-02"), nil
-}
-
-func ImportTransactionRecordFromCSV(filePath string) error {
-	file, err := os.Open(filePath)
+	batch, err := newImportBatch(ImportKindCard, filePath, opts)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	reader.FieldsPerRecord = -1 // 允许字段数量不一致
+	batch.Header = reader.Header()
+	// 流式读取时总行数只能估计，读取器无法估计时进度只汇报已处理的行数
+	total := 0
+	if e, ok := reader.(RowEstimator); ok {
+		total = e.EstimatedRows()
+	}
+	progress := newImportProgress(opts.Progress, total)
 
-	accountNumber := ""
-	// 跳过不必要的行
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+	chunk := make([]ParsedTransaction, 0, importChunkSize)
+	cards := map[cardKey]bool{}
+	var locks periodLocks
+	flush := func(tx *gorm.DB) error {
+		if len(chunk) == 0 {
+			return nil
 		}
+		ids := make([]string, 0, len(chunk))
+		for _, p := range chunk {
+			ids = append(ids, p.TransactionID)
+			cards[cardKey{p.Nickname, p.CardNumber}] = true
+		}
+		existing, err := existingTransactions(tx, ids)
 		if err != nil {
 			return err
 		}
-		// 读取第5行第一列以获取accountNumber
-		line := strings.TrimSpace(record[0])
-		if strings.HasPrefix(line, "Account: ") {
-			accountNumber = extractAccountNumber(line)
-		}
 
-		if len(record) > 5 {
-			return fmt.Errorf("只能识别Date、Transaction ID、Payment Method、Amount、Currency 五列 %s", record)
+		pending := make([]ParsedTransaction, 0, len(chunk))
+		transactions := make([]Transaction, 0, len(chunk))
+		for _, p := range chunk {
+			if old, ok := existing[p.TransactionID]; ok {
+				// 已存在的交易只更新状态等会变化的字段，并记录修改历史
+				batch.ExistingRows++
+				changes, statusChanged := transactionChanges(old, p)
+				if len(changes) == 0 {
+					continue
+				}
+				if err := locks.checkTransaction(old.Nickname, old.TransactionTime); err != nil {
+					batch.reject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: err.Error() + "，不能修改"})
+					continue
+				}
+				if err := updateTransaction(tx, batch.ID, changes); err != nil {
+					return err
+				}
+				batch.UpdatedRows++
+				if statusChanged {
+					batch.StatusChangedRows++
+				}
+				continue
+			}
+			if err := locks.checkTransaction(p.Nickname, p.TransactionTime); err != nil {
+				batch.reject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: err.Error()})
+				continue
+			}
+			trans := p.Transaction
+			trans.Provider = opts.Provider
+			trans.BatchID = batch.ID
+			trans.SourceRow = p.Row
+			pending = append(pending, p)
+			transactions = append(transactions, trans)
 		}
 
-		if len(record) == 5 && record[0] == "Date" && record[1] == "Transaction ID" {
-			break
+		inserted, failed := insertTransactions(tx, transactions)
+		for i, err := range failed {
+			batch.reject(RejectedRow{Row: pending[i].Row, Raw: pending[i].Raw, Reason: "写入数据库失败: " + err.Error()})
 		}
+		batch.InsertedRows += inserted
+		// 查重之后才写入的重复行（如并发导入）也由 ON DUPLICATE KEY 忽略
+		batch.ExistingRows += len(transactions) - inserted - len(failed)
+		progress.add(len(chunk))
+		chunk = chunk[:0]
+		return nil
 	}
 
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
+	err = batch.write(func(tx *gorm.DB) error {
+		var err error
+		if locks, err = loadPeriodLocks(tx); err != nil {
 			return err
 		}
-
-		// 检查是否为总计行
-		if record[2] == "Total Amount Billed" || record[2] == "Total Amount billed" {
-			break
-		}
-
-		if len(record) < 5 {
-			continue // 跳过字段不足的记录
-		}
-
-		// 解析行数据到 Transaction 结构
-		var trans TransactionRecord
-		trans.Date, err = parseDate(record[0]) // 假设parseDate能够正确处理日期格式
+		err = eachTransaction(reader, func(p ParsedTransaction) error {
+			batch.TotalRows++
+			chunk = append(chunk, p)
+			if len(chunk) >= importChunkSize {
+				return flush(tx)
+			}
+			return nil
+		}, func(r RejectedRow) {
+			if !r.Warning {
+				batch.TotalRows++
+				progress.add(1)
+			}
+			batch.reject(r)
+		})
 		if err != nil {
-			// err := fmt.Errorf("%s %s %s", record[0], record[1], record[2])
 			return err
 		}
-		x, err := strconv.ParseFloat(record[3], 64)
-		trans.Account = accountNumber
-		trans.TransactionID = record[1]
-		trans.PaymentMethod = record[2][len(record[2])-4:]
-		trans.Amount = x
-		trans.Currency = record[4]
-		if err != nil {
-			log.Printf("Failed to parse amount: %v\n", err)
+		if err := flush(tx); err != nil {
 			return err
 		}
-
-		var target Transaction
-		db.Table("transaction").Where("transaction_type = ?", "交易清算").
-			Where("nickname = ?", accountNumber).
-			Where("card_number = ?", record[2][len(record[2])-4:]).
-			Where("is_judge = ?", "false").
-			Where("order_amount = ?", -x).
-			First(&target)
-
-		if target.TransactionID != "" {
-			trans.IsTradingAuthorization = true
-			db.Model(&target).Update("is_judge", true)
+		// 导入涉及的卡重新关联退款、撤销、清算与交易授权
+		keys := make([]cardKey, 0, len(cards))
+		for k := range cards {
+			keys = append(keys, k)
 		}
+		return relinkAuthorizations(tx, keys)
+	})
+	return batch, err
+}
 
-		var existing TransactionRecord
-		db.Where("transaction_id = ? AND account = ?", trans.TransactionID, trans.Account).First(&existing)
-		if existing.TransactionID != "" {
-			// 如果存在，则更新记录
-			db.Model(&existing).Updates(map[string]interface{}{
-				"is_trading_authorization": trans.IsTradingAuthorization,
-				// 根据需要更新其他字段
-			})
-		} else {
-			result := db.Create(&trans)
-			if result.Error != nil {
-				log.Printf("Failed to save transaction: %v\n", result.Error)
-				// 可以选择继续或中断处理
-				return result.Error
-			}
-		}
-		// 保存到数据库
+// ParsedRecord 从广告平台账单中解析出的一行，Row 为源文件中的行号，Section 为所在区块的下标（没有区块时为-1）
+type ParsedRecord struct {
+	Row     int
+	Raw     []string
+	Section int
+	TransactionRecord
+}
 
-	}
+// BillingSection 账单中以总计行结束的一个广告账户区块（如 FB 账单的 Account 区块），
+// 导入后按账户核对总计金额与实际导入的金额
+type BillingSection struct {
+	Account  string
+	Rows     int   // 解析成功的数据行数
+	Total    Money // 总计行中的金额
+	HasTotal bool  // 是否读到了可以解析的总计行
+}
+
+// BillingFile 解析后的广告平台账单文件，各平台的解析器见 importer 包。
+// 账单行的 Account、PaymentMethod（卡号后四位）和 Amount（卡上扣款的正数金额）用于匹配交易清算
+type BillingFile struct {
+	Header   []string          // 列标题行
+	Sections []*BillingSection // 没有总计行的格式为空，不做总计核对
+	Rows     []ParsedRecord
+	Rejects  []RejectedRow
+}
 
+// checkRecordOwner 交易ID是账单的主键，已存在的账单属于其他账户或平台时返回错误
+func checkRecordOwner(existing TransactionRecord, account string, platform string) error {
+	if existing.Account != account || existing.Platform != platform {
+		return fmt.Errorf("交易ID %s 已存在于 %s 平台的账户 %s", existing.TransactionID, existing.Platform, existing.Account)
+	}
 	return nil
 }
 
-// extractAccountNumber 从给定的字符串中提取账户数字部分
-// 假设格式为 "Account: 123456789"，这里仅作为示例
-func extractAccountNumber(s string) string {
-	parts := strings.Split(s, " ")
-	if len(parts) > 1 && strings.HasPrefix(parts[0], "Account:") {
-		return parts[1]
+// ImportTransactionRecords 导入 filePath 解析出的广告平台账单，账单行记录 opts.Provider 作为平台；
+// 写入后对涉及的账户和卡按 Reconcile 重新核对交易清算。全部写入在一个事务中完成，见 ImportOptions.BestEffort
+func ImportTransactionRecords(billing *BillingFile, filePath string, opts ImportOptions) (*ImportBatch, error) {
+	if opts.Provider == "" {
+		opts.Provider = DefaultBillingPlatform
+	}
+	batch, err := newImportBatch(ImportKindBilling, filePath, opts)
+	if err != nil {
+		return nil, err
 	}
-	return "" // 如果格式不正确，返回空字符串
+	batch.Header = billing.Header
+	batch.TotalRows = len(billing.Rows)
+	for _, r := range billing.Rejects {
+		if !r.Warning {
+			batch.TotalRows++
+		}
+		batch.reject(r)
+	}
+	progress := newImportProgress(opts.Progress, batch.TotalRows)
+	progress.add(batch.SkippedRows)
+
+	accounts := newAccountCounter(billing.Sections)
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+	err = batch.write(func(tx *gorm.DB) error {
+		locks, err := loadPeriodLocks(tx)
+		if err != nil {
+			return err
+		}
+		var written []TransactionRecord
+		for _, p := range billing.Rows {
+			progress.add(1)
+			count := accounts.get(p.Account)
+			count.Rows++
+			trans := p.TransactionRecord
+			trans.Platform = opts.Provider
+			trans.BatchID = batch.ID
+			trans.SourceRow = p.Row
+
+			var existing TransactionRecord
+			if err := tx.Where("transaction_id = ?", trans.TransactionID).
+				Limit(1).Find(&existing).Error; err != nil {
+				return err
+			}
+			lockErr := locks.checkRecord(trans.Account, trans.Date)
+			if existing.TransactionID != "" {
+				if err := checkRecordOwner(existing, trans.Account, trans.Platform); err != nil {
+					batch.reject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: err.Error()})
+					count.Skipped++
+					continue
+				}
+				// 已存在的账单保持原有的核对结果，未核对的在下面重新核对；已结账的不再核对
+				batch.ExistingRows++
+				count.Existing++
+				count.Imported += trans.Amount
+				if lockErr != nil {
+					continue
+				}
+			} else if lockErr != nil {
+				// 已结账期间的新账单不写入，解锁后需要重新上传源文件
+				batch.reject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: lockErr.Error()})
+				count.Skipped++
+				continue
+			} else {
+				// 保存到数据库，写入失败的行不占用交易清算
+				if err := tx.Create(&trans).Error; err != nil {
+					log.Printf("Failed to save transaction: %v\n", err)
+					batch.reject(RejectedRow{Row: p.Row, Raw: p.Raw, Reason: "写入数据库失败: " + err.Error()})
+					count.Skipped++
+					continue
+				}
+				batch.InsertedRows++
+				count.Inserted++
+				count.Imported += trans.Amount
+			}
+			written = append(written, trans)
+		}
+
+		matched, err := reconcileImported(tx, batch, reconcileKeys(written))
+		if err != nil {
+			return err
+		}
+		for account, n := range matched {
+			accounts.get(account).Matched += n
+			batch.MatchedRows += n
+		}
+		// 核对每个账户的总计行与实际导入的金额
+		batch.TotalsMismatch = accounts.checkTotals()
+		batch.Accounts = accounts.list
+		return nil
+	})
+	return batch, err
 }
 
-func GetTransactionRecords(pageSize int, pageNum int, Account string, PaymentMethod string, startTime int, endTime int, set int) ([]TransactionRecord, error, int64) {
+// GetTransactionRecords 分页查询账单记录，start_time、end_time 按 loc 中的日期筛选
+func GetTransactionRecords(pageSize int, pageNum int, Account string, PaymentMethod string, startTime int, endTime int, loc *time.Location, set int) ([]TransactionRecord, error, int64) {
 
 	var transactionRecords []TransactionRecord
 	query := db.Model(&TransactionRecord{})
@@ -283,14 +445,8 @@ func GetTransactionRecords(pageSize int, pageNum int, Account string, PaymentMet
 		countQuery = countQuery.Where("payment_method = ?", PaymentMethod)
 	}
 
-	if startTime != 0 && endTime != 0 {
-		startTimeT := time.Unix(int64(startTime), 0).UTC()
-		endTimeT := time.Unix(int64(endTime), 0).UTC()
-		startDate := startTimeT.Format("2006-01-02")
-		endDate := endTimeT.Format("2006-01-02")
-		query = query.Where("Date BETWEEN ? AND ?", startDate, endDate)
-		countQuery = countQuery.Where("Date BETWEEN ? AND ?", startDate, endDate)
-	}
+	query = whereRecordDate(query, startTime, endTime, loc)
+	countQuery = whereRecordDate(countQuery, startTime, endTime, loc)
 
 	// 执行查询并获取交易记录
 	// result := query.Find(&transactionRecords).Count(&total)
@@ -316,7 +472,8 @@ func GetTransactionRecords(pageSize int, pageNum int, Account string, PaymentMet
 	return transactionRecords, nil, total
 }
 
-func GetTransactions(pageSize int, pageNum int, cardNumber string, transactionType string, startTime int, endTime int, is_judge int, set int) ([]Transaction, error, int) {
+// GetTransactions 分页查询交易，start_time、end_time 按 loc 中的日期筛选
+func GetTransactions(pageSize int, pageNum int, cardNumber string, transactionType string, startTime int, endTime int, loc *time.Location, is_judge int, set int) ([]Transaction, error, int) {
 
 	var transactions []Transaction
 
@@ -334,19 +491,15 @@ func GetTransactions(pageSize int, pageNum int, cardNumber string, transactionTy
 		countQuery = countQuery.Where("transaction_type = ?", transactionType)
 	}
 
-	if startTime != 0 && endTime != 0 {
-		startTimeT := time.Unix(int64(startTime), 0).UTC()
-		endTimeT := time.Unix(int64(endTime), 0).UTC()
-		query = query.Where("transaction_time BETWEEN ? AND ?", startTimeT, endTimeT)
-		countQuery = countQuery.Where("transaction_time BETWEEN ? AND ?", startTimeT, endTimeT)
-	}
+	query = whereTransactionTime(query, startTime, endTime, loc)
+	countQuery = whereTransactionTime(countQuery, startTime, endTime, loc)
 	if is_judge != -1 {
 		if is_judge == 0 {
-			query = query.Where("is_judge = ? AND transaction_type = ?", 0, "交易清算")
-			countQuery = countQuery.Where("is_judge = ? AND transaction_type = ?", 0, "交易清算")
+			query = query.Where("is_judge = ? AND transaction_type = ?", 0, TypeSettlement)
+			countQuery = countQuery.Where("is_judge = ? AND transaction_type = ?", 0, TypeSettlement)
 		} else if is_judge == 1 {
-			query = query.Where("is_judge = ? AND transaction_type = ?", 1, "交易清算")
-			countQuery = countQuery.Where("is_judge = ? AND transaction_type = ?", 1, "交易清算")
+			query = query.Where("is_judge = ? AND transaction_type = ?", 1, TypeSettlement)
+			countQuery = countQuery.Where("is_judge = ? AND transaction_type = ?", 1, TypeSettlement)
 		}
 	}
 
@@ -376,166 +529,224 @@ func GetTransactions(pageSize int, pageNum int, cardNumber string, transactionTy
 	return transactions, nil, int(total)
 }
 
-func CalVccBalance(fb_id string, cardnumber string, startTime int, endTime int) (float64, error) {
+// transactionDay 交易所在日期的 SQL 表达式，按当天汇率换算时使用
+const transactionDay = "DATE_FORMAT(transaction_time, '%Y-%m-%d')"
 
-	// 初始化变量
-	var initialAmount, increaseAmount float64
-	// decreaseAmount float64
+// recordDay 账单日期的 SQL 表达式，DATE 列直接取出时驱动会返回 time.Time
+const recordDay = "DATE_FORMAT(date, '%Y-%m-%d')"
 
-	// 查找与特定卡号相关的开卡交易以获取初始金额
-	var initTrans Transaction
-	if err := db.Where("card_number = ? AND transaction_type = ? and nickname = ?", cardnumber, "开卡", fb_id).First(&initTrans).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return 0, fmt.Errorf("没有找到与卡号 %s 相关的开卡交易", cardnumber)
-		}
-		return 0, err
-	}
-	initialAmount = initTrans.OrderAmount
-
-	// 准备时间条件
-	var timeCondition string
-	var timeValues []interface{}
-	if startTime != 0 && endTime != 0 {
-		// 如果startTime和endTime都不为0，则添加时间范围条件
-		timeCondition = "AND transaction_time BETWEEN ? AND ?"
-		timeValues = append(timeValues, time.Unix(int64(startTime), 0).UTC(), time.Unix(int64(endTime), 0).UTC())
-	}
-
-	// 计算增加余额的交易总和
-	var sumIncrease float64
-	if err := db.Table("transaction").
-		Select("SUM(order_amount) as total").
-		Where("card_number = ? AND transaction_type IN ?"+timeCondition, append([]interface{}{cardnumber, []string{"卡充值", "交易退款", "交易授权", "卡充退", "交易授权撤销"}}, timeValues...)...).
-		Where("nickname = ?", fb_id).
-		Scan(&sumIncrease).Error; err != nil {
-		return 0, err
-	}
-	increaseAmount = sumIncrease
-	// fmt.Print(increaseAmount)
-	// 计算减少余额的交易总和
-	// var sumDecrease float64
-	// if err := db.Table("transaction").
-	//     Select("SUM(order_amount) as total").
-	//     Where("card_number = ? AND transaction_type IN ? "+timeCondition, append([]interface{}{cardnumber, []string{"交易授权", "卡充退"}}, timeValues...)...).
-	//     Where("nickname = ?", fb_id).
-	//     Scan(&sumDecrease).Error; err != nil {
-	//     return 0, err
-	// }
-	// decreaseAmount = sumDecrease
-
-	// 计算最终余额
-	balance := initialAmount + increaseAmount
-	// + decreaseAmount  // 注意这里应该是增加-减少
-
-	return balance, nil
-
-}
-
-func CalVccTotalDeplete(fb_id string, cardnumber string, startTime int, endTime int) (float64, error) {
-
-	// var sumDecrease float64
-	// if err := db.Table("transaction").
-	// 	Select("SUM(order_amount) as total").
-	// 	Where("card_number = ? AND transaction_type IN ?", cardnumber, []string{"交易授权"}).Scan(&sumDecrease).Error; err != nil {
-	// 	return 0, err // 如果查询或扫描失败，返回错误
-	// }
-	// decreaseAmount := sumDecrease
-
-	// // 计算最终余额
-	// balance := decreaseAmount
-
-	// return balance, nil
-	var sumDecrease float64
-	// 构建查询条件
-	var conditions []interface{}
-	query := db.Table("transaction").
-		Select("SUM(order_amount) as total")
+// CalVccBalance 按余额规则（见 LedgerRule）计算卡余额，start_time、end_time 按 loc 中的日期筛选。
+// convert 为空时各交易必须是同一币种，否则按交易日期的汇率换算为 convert 币种；返回余额及其币种
+func CalVccBalance(fb_id string, cardnumber string, startTime int, endTime int, loc *time.Location, convert string) (Money, string, error) {
+	breakdown, err := VccBalanceBreakdown(fb_id, cardnumber, startTime, endTime, loc, convert)
+	if err != nil {
+		return 0, "", err
+	}
+	return breakdown.Balance, breakdown.Currency, nil
+}
 
-	// 添加卡号条件
-	conditions = append(conditions, cardnumber)
-	query = query.Where("card_number = ? and nickname = ?", cardnumber, fb_id)
+// CalVccTotalDeplete 计算卡的总消耗（交易授权的订单金额之和），时间范围和币种处理同 CalVccBalance
+func CalVccTotalDeplete(fb_id string, cardnumber string, startTime int, endTime int, loc *time.Location, convert string) (Money, string, error) {
+	query := db.Table("transaction").
+		Where("card_number = ? and nickname = ?", cardnumber, fb_id)
 
 	// 如果 startTime 和 endTime 都非零，则添加时间范围条件
-	if startTime != 0 && endTime != 0 {
-		// 假设 transaction_time 是 UNIX 时间戳（int 类型）
-		startTime1 := time.Unix(int64(startTime), 0).UTC()
-		endTime1 := time.Unix(int64(endTime), 0).UTC()
-		conditions = append(conditions, startTime1, endTime1)
-		query = query.Where("transaction_time BETWEEN ? AND ?", startTime1, endTime1)
-	}
+	query = whereTransactionTime(query, startTime, endTime, loc)
 
 	// 添加交易类型条件
-	conditions = append(conditions, []string{"交易授权"})                            // 注意使用 ... 来展开切片
-	query = query.Where("transaction_type IN ?", conditions[len(conditions)-1:]) // 注意这里的切片操作
+	query = query.Where("transaction_type IN ?", []string{TypeAuthorization})
 
-	// 执行查询并扫描结果
-	if err := query.Scan(&sumDecrease).Error; err != nil {
-		return 0, err // 如果查询或扫描失败，返回错误
+	rows, err := sumByCurrency(query, "order_amount", "order_currency", transactionDay)
+	if err != nil {
+		return 0, "", err
 	}
+	return foldAmounts(rows, convert)
+}
 
-	// 计算最终余额（这里假设 decreaseAmount 就是你要的余额减少量）
-	// 注意：通常我们需要一个初始的余额值来减去 decreaseAmount，但这里没有提供，所以只返回减少量
-	balance := sumDecrease // 如果 balance 是减少量，这里直接赋值
-
-	return balance, nil
+// VccSummary 一张卡的余额和总消耗。
+// Opening、TopUps、Spend、Refunds、Other 为各类交易按余额规则对余额的影响，之和即 Balance
+type VccSummary struct {
+	CardNumber   string     `json:"card_number"`
+	Fb_id        string     `json:"fb_id"`
+	Opening      Money      `json:"opening"` // 开卡金额
+	TopUps       Money      `json:"top_ups"` // 充值减卡充退
+	Spend        Money      `json:"spend"`   // 交易授权（及按规则扣除的手续费）
+	Refunds      Money      `json:"refunds"` // 交易退款和交易授权撤销
+	Other        Money      `json:"other"`   // 其他有余额规则的交易类型
+	Balance      Money      `json:"balance"`
+	Deplete      Money      `json:"deplete"`
+	Currency     string     `json:"currency"`        // 金额的币种，换算时为换算后的币种
+	LastActivity *time.Time `json:"last_activity"`   // 最近一笔交易的时间，不受时间范围限制
+	Error        string     `json:"error,omitempty"` // 无法计算的原因，如没有开卡交易、币种混合
 }
 
-type PaginationResult struct {
-	CurrentPage map[string]struct {
-		Fb_id   string
-		Balance string
-		Deplete string
-	} // 当前页的结果
+// 卡汇总的排序字段，为空时按卡号
+const (
+	VccSortBalance      = "balance"
+	VccSortDeplete      = "deplete"
+	VccSortLastActivity = "last_activity"
+)
+
+// ErrInvalidVccSort 卡汇总的排序字段无效
+var ErrInvalidVccSort = errors.New("无效的排序字段")
+
+// VccSummaryQuery 卡汇总的排序、筛选和分页。阈值为严格比较，为 nil 时不筛选；
+// 无法计算的卡不满足任何阈值，排序时排在最后
+type VccSummaryQuery struct {
+	Sort         string
+	Desc         bool
+	BalanceBelow *Money
+	BalanceAbove *Money
+	DepleteBelow *Money
+	DepleteAbove *Money
+	PageSize     int
+	PageNum      int
 }
 
-// 实现分页的 ShowVccBalanceAndDeplete 函数
-func ShowVccBalanceAndDepletes(fb_id string, IDs []string, pageSize int, pageNum int, startTime int, endTime int) (*PaginationResult, error, int) {
+// match 卡是否满足阈值
+func (q VccSummaryQuery) match(s VccSummary) bool {
+	if q.BalanceBelow == nil && q.BalanceAbove == nil && q.DepleteBelow == nil && q.DepleteAbove == nil {
+		return true
+	}
+	return s.Error == "" &&
+		(q.BalanceBelow == nil || s.Balance < *q.BalanceBelow) &&
+		(q.BalanceAbove == nil || s.Balance > *q.BalanceAbove) &&
+		(q.DepleteBelow == nil || s.Deplete < *q.DepleteBelow) &&
+		(q.DepleteAbove == nil || s.Deplete > *q.DepleteAbove)
+}
 
-	if pageSize <= 0 || pageNum <= 0 {
-		return nil, errors.New("pageSize and pageNum must be positive integers"), 0
+// less 按排序字段比较两张卡，相同时按卡号
+func (q VccSummaryQuery) less(a, b VccSummary) bool {
+	if (a.Error == "") != (b.Error == "") && q.Sort != "" {
+		return a.Error == ""
+	}
+	var cmp int
+	switch q.Sort {
+	case VccSortBalance:
+		cmp = compareMoney(a.Balance, b.Balance)
+	case VccSortDeplete:
+		cmp = compareMoney(a.Deplete, b.Deplete)
+	case VccSortLastActivity:
+		switch {
+		case a.LastActivity == nil || b.LastActivity == nil:
+			if a.LastActivity != b.LastActivity {
+				return b.LastActivity == nil
+			}
+		case a.LastActivity.Before(*b.LastActivity):
+			cmp = -1
+		case a.LastActivity.After(*b.LastActivity):
+			cmp = 1
+		}
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.CardNumber, b.CardNumber)
+	}
+	if q.Desc {
+		return cmp > 0
 	}
+	return cmp < 0
+}
 
-	// 计算总项数
-	total := len(IDs)
+func compareMoney(a, b Money) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
 
-	// 计算当前页应该包含的ID索引范围
-	startIndex := (pageNum - 1) * pageSize
-	if startIndex >= total {
-		// 如果没有足够的ID来填充当前页，则返回一个空的当前页结果
-		return &PaginationResult{CurrentPage: map[string]struct {
-			Fb_id   string
-			Balance string
-			Deplete string
-		}{}}, nil, 0
+// GetVccSummaries 汇总广告账户下各卡的余额和总消耗，筛选、排序后再分页，返回当前页和筛选后的总数。
+// 排序需要全部卡的结果，汇总按 vccTotalsByCard 一次查询所有卡；时间范围和 convert 见 CalVccBalance
+func GetVccSummaries(fb_id string, cards []string, startTime int, endTime int, loc *time.Location, convert string, q VccSummaryQuery) ([]VccSummary, int, error) {
+	switch q.Sort {
+	case "", VccSortBalance, VccSortDeplete, VccSortLastActivity:
+	default:
+		return nil, 0, fmt.Errorf("%w %q，可选 %s、%s、%s", ErrInvalidVccSort, q.Sort, VccSortBalance, VccSortDeplete, VccSortLastActivity)
+	}
+	if q.PageSize <= 0 || q.PageNum <= 0 {
+		return nil, 0, errors.New("pageSize and pageNum must be positive integers")
 	}
 
-	endIndex := startIndex + pageSize
-	if endIndex > total {
-		endIndex = total
+	totals, err := vccTotalsByCard(fb_id, cards, startTime, endTime, loc, convert)
+	if err != nil {
+		return nil, 0, err
+	}
+	lastActivity, err := lastActivityByCard(fb_id, cards)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	summaries := make([]VccSummary, 0, len(cards))
+	for _, card := range cards {
+		t := totals[card]
+		s := VccSummary{
+			CardNumber: card,
+			Fb_id:      fb_id,
+			Opening:    t.Opening,
+			TopUps:     t.TopUps,
+			Spend:      t.Spend,
+			Refunds:    t.Refunds,
+			Other:      t.Other,
+			Balance:    t.Balance,
+			Deplete:    t.Deplete,
+			Currency:   t.Currency,
+			Error:      t.Error,
+		}
+		if at, ok := lastActivity[card]; ok {
+			s.LastActivity = &at
+		}
+		summaries = append(summaries, s)
 	}
+	rows, total := q.page(summaries)
+	return rows, total, nil
+}
 
-	// 只处理当前页范围内的ID
-	currentPageIDs := IDs[startIndex:endIndex]
+// page 筛选、排序后取第 PageNum 页，返回当前页和筛选后的总数
+func (q VccSummaryQuery) page(summaries []VccSummary) ([]VccSummary, int) {
+	matched := make([]VccSummary, 0, len(summaries))
+	for _, s := range summaries {
+		if q.match(s) {
+			matched = append(matched, s)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return q.less(matched[i], matched[j]) })
 
-	result := make(map[string]struct {
-		Fb_id   string
-		Balance string
-		Deplete string
-	})
-	for _, id := range currentPageIDs {
-		balance, _ := CalVccBalance(fb_id, id, startTime, endTime)
-		deplete, _ := CalVccTotalDeplete(fb_id, id, startTime, endTime)
-		result[id] = struct {
-			Fb_id   string
-			Balance string
-			Deplete string
-		}{
-			Fb_id:   fb_id,
-			Balance: fmt.Sprintf("%.2f", balance),
-			Deplete: fmt.Sprintf("%.2f", deplete)}
+	total := len(matched)
+	start := (q.PageNum - 1) * q.PageSize
+	if start >= total {
+		return []VccSummary{}, total
 	}
+	end := start + q.PageSize
+	if end > total {
+		end = total
+	}
+	return matched[start:end], total
+}
 
-	return &PaginationResult{CurrentPage: result}, nil, total
+// lastActivityByCard 各卡最近一笔交易的时间
+func lastActivityByCard(fb_id string, cards []string) (map[string]time.Time, error) {
+	result := make(map[string]time.Time, len(cards))
+	if len(cards) == 0 {
+		return result, nil
+	}
+	var rows []struct {
+		CardNumber   string
+		LastActivity time.Time
+	}
+	err := db.Table("transaction").
+		Select("card_number, MAX(transaction_time) AS last_activity").
+		Where("nickname = ? AND card_number IN ?", fb_id, cards).
+		Group("card_number").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		result[r.CardNumber] = r.LastActivity
+	}
+	return result, nil
 }
 
 func ShowVccID() ([]string, error) {
@@ -590,60 +801,30 @@ func ShowFBID(Account string) ([]string, []string, error) {
 	return []string{}, paymentMethods, nil
 }
 
-func CalVccDepleteByDate(year, month int, cardNumber string) (float64, error) {
-	// 将cardNumber模糊处理，仅保留前几位和后几位，以保护隐私
-	maskedCardNumber := cardNumber
-
-	// 计算开始和结束时间
-	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	end := start.AddDate(0, 1, -1).Add(24 * time.Hour) // 加上24小时以包括当天的23:59:59
-	// fmt.Print(start.Format("2006-01-02 00:00:00"), end.Format("2006-01-02 00:00:00"))
-	// 使用GORM查询
-	var totalAmount float64
-	err := db.Table("transaction").
-		Where("transaction_type = ? AND card_number LIKE ? AND DATE(transaction_time) BETWEEN ? AND ?", "交易授权", maskedCardNumber, start.Format("2006-01-02 00:00:00"), end.Format("2006-01-02 00:00:00")).
-		Select("SUM(transaction_amount) as total_amount").
-		Scan(&totalAmount).
-		Error
-
+// CalVccDepleteByDate 计算卡在某月的消耗（交易授权的交易金额之和），月份按 loc 中的日期划分，币种处理同 CalVccBalance
+func CalVccDepleteByDate(year, month int, cardNumber string, loc *time.Location, convert string) (Money, string, error) {
+	// 计算开始和结束时间，结束为下月1日0点（不含）
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 1, 0)
+	query := db.Table("transaction").
+		Where("transaction_type = ? AND card_number LIKE ? AND transaction_time >= ? AND transaction_time < ?", TypeAuthorization, cardNumber, start, end)
+	rows, err := sumByCurrency(query, "transaction_amount", "transaction_currency", transactionDay)
 	if err != nil {
-		return 0, err
+		return 0, "", err
 	}
-
-	return totalAmount, nil
+	return foldAmounts(rows, convert)
 }
 
-func CalFBbyaccount(account string, card_id string, startTime int, endTime int) (float64, error) {
-	var totalAmount float64
-	if startTime != 0 && endTime != 0 {
-		startTimeT := time.Unix(int64(startTime), 0).UTC()
-		endTimeT := time.Unix(int64(endTime), 0).UTC()
-		startDate := startTimeT.Format("2006-01-02")
-		endDate := endTimeT.Format("2006-01-02")
-		err := db.Table("transaction_record").
-			Where("account = ? AND payment_method LIKE ?", account, card_id).
-			Where("Date BETWEEN ? AND ?", startDate, endDate).
-			Select("SUM(amount) as total_amount").
-			Scan(&totalAmount).
-			Error
-		if err != nil {
-			return 0, err
-		}
-
-		return totalAmount, nil
-	} else {
-		err := db.Table("transaction_record").
-			Where("account = ? AND payment_method LIKE ?", account, card_id).
-			Select("SUM(amount) as total_amount").
-			Scan(&totalAmount).
-			Error
-		if err != nil {
-			return 0, err
-		}
-
-		return totalAmount, nil
+// CalFBbyaccount 计算广告账户用某张卡支付的账单金额之和，时间范围和币种处理同 CalVccBalance
+func CalFBbyaccount(account string, card_id string, startTime int, endTime int, loc *time.Location, convert string) (Money, string, error) {
+	query := db.Table("transaction_record").
+		Where("account = ? AND payment_method LIKE ?", account, card_id)
+	query = whereRecordDate(query, startTime, endTime, loc)
+	rows, err := sumByCurrency(query, "amount", "currency", recordDay)
+	if err != nil {
+		return 0, "", err
 	}
-
+	return foldAmounts(rows, convert)
 }
 
 func CalFBbyaccountList(account string, card_id string) ([]TransactionRecord, error) {
@@ -663,7 +844,7 @@ func CalFBbyaccountList(account string, card_id string) ([]TransactionRecord, er
 
 type TransactionSummary map[string][]struct {
 	Date     string
-	Amount   float64
+	Amount   Money
 	IsTicked bool
 	Note     string
 }
@@ -685,18 +866,18 @@ func Showfb_vccdata(account string) (TransactionSummary, error) {
 		if _, exists := summary[record.PaymentMethod]; !exists {
 			summary[record.PaymentMethod] = []struct {
 				Date     string
-				Amount   float64
+				Amount   Money
 				IsTicked bool
 				Note     string
 			}{}
 		}
 		summary[record.PaymentMethod] = append(summary[record.PaymentMethod], struct {
 			Date     string
-			Amount   float64
+			Amount   Money
 			IsTicked bool
 			Note     string
 		}{
-			Date:     record.Date,
+			Date:     string(record.Date),
 			Amount:   record.Amount,
 			IsTicked: record.IsTicked,
 			Note:     record.Note,
@@ -707,7 +888,19 @@ func Showfb_vccdata(account string) (TransactionSummary, error) {
 }
 
 func UpdateTransactionRecord(transaction_id string, isTicked bool, note string) error {
-	err := db.Table("transaction_record").
+	// 已结账期间的账单不能修改
+	var record TransactionRecord
+	if err := db.Select("transaction_id, account, date").Where("transaction_id = ?", transaction_id).Limit(1).Find(&record).Error; err != nil {
+		return err
+	}
+	locks, err := loadPeriodLocks(db)
+	if err != nil {
+		return err
+	}
+	if err := locks.checkRecord(record.Account, record.Date); err != nil {
+		return err
+	}
+	err = db.Table("transaction_record").
 		Where("transaction_id = ?", transaction_id).
 		Order("payment_method ASC, date ASC").
 		Updates(map[string]interface{}{
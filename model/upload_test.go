@@ -0,0 +1,421 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+const benchRows = 100000
+
+// benchReader 生成 n 行交易的读取器，只测量查重和写入，文件解析的基准见 importer 包
+type benchReader struct{ n int }
+
+func (r benchReader) Header() []string { return []string{"交易编号"} }
+
+func (r benchReader) Each(onRow func(ParsedTransaction) error, onReject func(RejectedRow)) error {
+	types := []string{TypeAuthorization, TypeSettlement, TypeTopUp, TypeAuthReversal}
+	for i := 0; i < r.n; i++ {
+		amount := -Money(i % 5000)
+		p := ParsedTransaction{Row: i + 2, Transaction: Transaction{
+			TransactionID:     fmt.Sprintf("BENCH%012d", i),
+			TransactionTime:   time.Date(2024, time.Month(i%6+1), i%28+1, i%24, i%60, i%60, 0, time.UTC),
+			CardNumber:        fmt.Sprintf("%04d", i%3000),
+			Nickname:          fmt.Sprintf("%d", 189505894+i%20),
+			TransactionType:   types[i%len(types)],
+			OrderAmount:       amount,
+			TransactionAmount: amount,
+		}}
+		if err := onRow(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r benchReader) Close() error { return nil }
+
+// sliceReader 按顺序返回给定的行，用于不经过文件解析的导入测试
+type sliceReader []ParsedTransaction
+
+func (r sliceReader) Header() []string { return []string{"交易编号"} }
+
+func (r sliceReader) Each(onRow func(ParsedTransaction) error, onReject func(RejectedRow)) error {
+	for _, p := range r {
+		if err := onRow(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r sliceReader) Close() error { return nil }
+
+// openBenchDB 连接 WB_BENCH_DSN 指向的测试用 MySQL 库并建表，没有设置时跳过
+func openBenchDB(b testing.TB, models ...interface{}) {
+	dsn := os.Getenv("WB_BENCH_DSN")
+	if dsn == "" {
+		b.Skip("set WB_BENCH_DSN to run against MySQL")
+	}
+	var err error
+	db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
+		Logger:                 logger.Default.LogMode(logger.Silent),
+		SkipDefaultTransaction: true,
+		NamingStrategy:         schema.NamingStrategy{SingularTable: true},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := db.AutoMigrate(models...); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkImportTransactions 完整导入（查重 + 批量写入），需要设置 WB_BENCH_DSN 指向一个测试用 MySQL 库
+func BenchmarkImportTransactions(b *testing.B) {
+	openBenchDB(b, &Transaction{}, &ImportBatch{}, &ImportReject{})
+
+	// 批次记录文件哈希，需要一个真实存在的文件
+	f, err := os.CreateTemp(b.TempDir(), "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	f.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		db.Where("transaction_id LIKE ?", "BENCH%").Delete(&Transaction{})
+		b.StartTimer()
+
+		batch, err := ImportTransactions(benchReader{n: benchRows}, f.Name(), ImportOptions{FileName: "bench.xlsx"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if batch.InsertedRows != benchRows {
+			b.Fatalf("inserted %d rows, want %d", batch.InsertedRows, benchRows)
+		}
+	}
+}
+
+// importRowByRow 原先的写入方式：文件内重复的交易ID只保留第一行，逐行查询是否已存在，按交易时间排序后逐行写入
+func importRowByRow(rows []ParsedTransaction, batchID uint) error {
+	seen := map[string]bool{}
+	var transactions []Transaction
+	for _, p := range rows {
+		if seen[p.TransactionID] {
+			continue
+		}
+		seen[p.TransactionID] = true
+		var existing Transaction
+		db.Table("transaction").Where("transaction_id = ?", p.TransactionID).Limit(1).Find(&existing)
+		if existing.TransactionID != "" {
+			continue
+		}
+		trans := p.Transaction
+		trans.BatchID = batchID
+		trans.SourceRow = p.Row
+		transactions = append(transactions, trans)
+	}
+	sort.Stable(ByTransactionTime(transactions))
+	for _, trans := range transactions {
+		if err := db.Create(&trans).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestImportTransactionsMatchesRowByRow 流式批量导入与原先逐行查重、逐行写入的结果相同，需要设置 WB_BENCH_DSN
+func TestImportTransactionsMatchesRowByRow(t *testing.T) {
+	openBenchDB(t, &Transaction{}, &ImportBatch{}, &ImportReject{}, &TransactionChange{},
+		&AuthorizationLink{}, &PeriodLock{})
+	const prefix = "EQTEST"
+	f, err := os.CreateTemp(t.TempDir(), "eqtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// 超过一批的行数，包含文件内重复的交易ID和数据库中已有的交易
+	types := []string{TypeAuthorization, TypeSettlement, TypeTopUp, TypeAuthReversal, TypeRefund}
+	var rows []ParsedTransaction
+	for i := 0; i < 2*importChunkSize+500; i++ {
+		id := i
+		if i%97 == 0 && i > 0 {
+			id = i - 1
+		}
+		amount := -Money(i%5000 + 1)
+		rows = append(rows, ParsedTransaction{Row: i + 2, Transaction: Transaction{
+			TransactionID:     fmt.Sprintf("%s%08d", prefix, id),
+			TransactionTime:   time.Date(2024, time.Month(i%6+1), i%28+1, i%24, i%60, 0, 0, time.UTC),
+			CardNumber:        fmt.Sprintf("%04d", i%50),
+			Nickname:          prefix,
+			TransactionType:   types[i%len(types)],
+			OrderAmount:       amount,
+			OrderCurrency:     "USD",
+			TransactionAmount: amount,
+			TransactionStatus: "成功",
+		}})
+	}
+	var seed []Transaction
+	for i := 0; i < len(rows); i += 10 {
+		seed = append(seed, rows[i].Transaction)
+	}
+
+	snapshot := func(run func() error) []Transaction {
+		if err := db.Where("transaction_id LIKE ?", prefix+"%").Delete(&Transaction{}).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateInBatches(seed, 500).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := run(); err != nil {
+			t.Fatal(err)
+		}
+		var stored []Transaction
+		if err := db.Where("transaction_id LIKE ?", prefix+"%").Order("transaction_id ASC").Find(&stored).Error; err != nil {
+			t.Fatal(err)
+		}
+		for i := range stored {
+			stored[i].BatchID = 0 // 两次导入的批次号不同
+		}
+		return stored
+	}
+	t.Cleanup(func() { db.Where("transaction_id LIKE ?", prefix+"%").Delete(&Transaction{}) })
+
+	old := snapshot(func() error { return importRowByRow(rows, 1) })
+	streamed := snapshot(func() error {
+		_, err := ImportTransactions(sliceReader(rows), f.Name(), ImportOptions{BestEffort: true})
+		return err
+	})
+	if len(old) != len(streamed) {
+		t.Fatalf("row by row stored %d rows, streaming %d", len(old), len(streamed))
+	}
+	for i := range old {
+		if !reflect.DeepEqual(old[i], streamed[i]) {
+			t.Errorf("row %d:\n row by row %+v\n streaming  %+v", i, old[i], streamed[i])
+		}
+	}
+}
+
+const (
+	benchCards    = 5000
+	benchNickname = "BENCHCARDS"
+)
+
+// seedBenchCards 为 benchCards 张卡各写入开卡、充值、授权、退款等交易，部分卡没有开卡交易，部分授权为正数
+func seedBenchCards(b testing.TB) []string {
+	openBenchDB(b, &Transaction{}, &LedgerRule{})
+	if err := seedLedgerRules(); err != nil {
+		b.Fatal(err)
+	}
+	if err := db.Where("nickname = ?", benchNickname).Delete(&Transaction{}).Error; err != nil {
+		b.Fatal(err)
+	}
+	types := []string{TypeTopUp, TypeAuthorization, TypeAuthorization, TypeSettlement, TypeAuthReversal,
+		TypeAuthorization, TypeRefund, TypeTopUpRefund, TypeAuthorization}
+	cards := make([]string, 0, benchCards)
+	var rows []Transaction
+	for c := 0; c < benchCards; c++ {
+		card := fmt.Sprintf("BENCH%06d", c)
+		cards = append(cards, card)
+		base := time.Date(2024, time.Month(c%6+1), c%28+1, 8, 0, 0, 0, time.UTC)
+		if c%50 != 0 {
+			rows = append(rows, Transaction{
+				TransactionID: fmt.Sprintf("%s-0", card), TransactionTime: base, CardNumber: card, Nickname: benchNickname,
+				TransactionType: TypeOpenCard, OrderAmount: Money(10000 + c%700), OrderCurrency: "USD",
+			})
+		}
+		for i, t := range types {
+			amount := Money(100 + (c*7+i*13)%2000)
+			if (t == TypeAuthorization || t == TypeTopUpRefund) && (c%7 != 0 || i != 1) {
+				amount = -amount
+			}
+			rows = append(rows, Transaction{
+				TransactionID: fmt.Sprintf("%s-%d", card, i+1), TransactionTime: base.Add(time.Duration(i) * 9 * time.Hour),
+				CardNumber: card, Nickname: benchNickname, TransactionType: t,
+				OrderAmount: amount, OrderCurrency: "USD", TransactionAmount: amount, TransactionFee: Money(i % 3),
+			})
+		}
+	}
+	if err := db.CreateInBatches(rows, 2000).Error; err != nil {
+		b.Fatal(err)
+	}
+	return cards
+}
+
+// BenchmarkVccTotalsPerCard 逐张卡调用 CalVccBalance、CalVccTotalDeplete，作为对照
+func BenchmarkVccTotalsPerCard(b *testing.B) {
+	cards := seedBenchCards(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, card := range cards {
+			CalVccBalance(benchNickname, card, 0, 0, time.UTC, "")
+			if _, _, err := CalVccTotalDeplete(benchNickname, card, 0, 0, time.UTC, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// TestVccTotalsByCardMatchesPerCard 一次汇总全部卡的结果与逐张调用 CalVccBalance、CalVccTotalDeplete 一致，需要设置 WB_BENCH_DSN
+func TestVccTotalsByCardMatchesPerCard(t *testing.T) {
+	cards := seedBenchCards(t)
+	start := int(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC).Unix())
+	end := int(time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC).Unix())
+	for _, r := range [][2]int{{0, 0}, {start, end}} {
+		totals, err := vccTotalsByCard(benchNickname, cards, r[0], r[1], time.UTC, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, card := range cards {
+			balance, _, balanceErr := CalVccBalance(benchNickname, card, r[0], r[1], time.UTC, "")
+			deplete, _, _ := CalVccTotalDeplete(benchNickname, card, r[0], r[1], time.UTC, "")
+			got := totals[card]
+			if got.Balance != balance || got.Deplete != deplete || (balanceErr != nil) != (got.Error != "") {
+				t.Fatalf("%s: got balance %s deplete %s error %q, want %s %s %v", card, got.Balance, got.Deplete, got.Error, balance, deplete, balanceErr)
+			}
+			if sum := got.Opening + got.TopUps + got.Spend + got.Refunds + got.Other; sum != got.Balance {
+				t.Fatalf("%s: categories sum to %s, balance %s", card, sum, got.Balance)
+			}
+		}
+	}
+}
+
+// BenchmarkVccTotalsByCard 一次汇总全部卡，结果与逐张计算的一致性见 TestVccTotalsByCardMatchesPerCard
+func BenchmarkVccTotalsByCard(b *testing.B) {
+	cards := seedBenchCards(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := vccTotalsByCard(benchNickname, cards, 0, 0, time.UTC, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetVccSummaries 汇总全部卡后按余额排序、筛选并取第一页
+func BenchmarkGetVccSummaries(b *testing.B) {
+	cards := seedBenchCards(b)
+	below := Money(20000)
+	query := VccSummaryQuery{Sort: VccSortBalance, Desc: true, BalanceBelow: &below, PageSize: 20, PageNum: 1}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := GetVccSummaries(benchNickname, cards, 0, 0, time.UTC, "", query); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestVccSummaryQueryPage(t *testing.T) {
+	at := func(day int) *time.Time {
+		v := time.Date(2024, 4, day, 0, 0, 0, 0, time.UTC)
+		return &v
+	}
+	money := func(m Money) *Money { return &m }
+	summaries := []VccSummary{
+		{CardNumber: "0004", Balance: 5000, Deplete: 100, LastActivity: at(3)},
+		{CardNumber: "0002", Balance: -200, Deplete: 900, LastActivity: at(5)},
+		{CardNumber: "0005", Error: "没有找到与卡号 0005 相关的开卡交易", Deplete: 300},
+		{CardNumber: "0001", Balance: 5000, Deplete: 500},
+		{CardNumber: "0003", Balance: 0, Deplete: 0, LastActivity: at(1)},
+	}
+
+	tests := []struct {
+		name      string
+		q         VccSummaryQuery
+		want      []string
+		wantTotal int
+	}{
+		{
+			name:      "默认按卡号",
+			q:         VccSummaryQuery{PageSize: 10, PageNum: 1},
+			want:      []string{"0001", "0002", "0003", "0004", "0005"},
+			wantTotal: 5,
+		},
+		{
+			name:      "按余额升序，相同时按卡号，无法计算的排在最后",
+			q:         VccSummaryQuery{Sort: VccSortBalance, PageSize: 10, PageNum: 1},
+			want:      []string{"0002", "0003", "0001", "0004", "0005"},
+			wantTotal: 5,
+		},
+		{
+			name:      "按余额降序，无法计算的仍排在最后",
+			q:         VccSummaryQuery{Sort: VccSortBalance, Desc: true, PageSize: 10, PageNum: 1},
+			want:      []string{"0004", "0001", "0003", "0002", "0005"},
+			wantTotal: 5,
+		},
+		{
+			name:      "按总消耗降序",
+			q:         VccSummaryQuery{Sort: VccSortDeplete, Desc: true, PageSize: 10, PageNum: 1},
+			want:      []string{"0002", "0001", "0004", "0003", "0005"},
+			wantTotal: 5,
+		},
+		{
+			name:      "按最近交易时间，没有交易的排在最后",
+			q:         VccSummaryQuery{Sort: VccSortLastActivity, PageSize: 10, PageNum: 1},
+			want:      []string{"0003", "0004", "0002", "0001", "0005"},
+			wantTotal: 5,
+		},
+		{
+			name:      "按最近交易时间降序，没有交易的仍排在最后",
+			q:         VccSummaryQuery{Sort: VccSortLastActivity, Desc: true, PageSize: 10, PageNum: 1},
+			want:      []string{"0002", "0004", "0003", "0001", "0005"},
+			wantTotal: 5,
+		},
+		{
+			name:      "余额阈值为严格比较，无法计算的卡不满足",
+			q:         VccSummaryQuery{BalanceBelow: money(5000), PageSize: 10, PageNum: 1},
+			want:      []string{"0002", "0003"},
+			wantTotal: 2,
+		},
+		{
+			name:      "多个阈值同时满足",
+			q:         VccSummaryQuery{BalanceAbove: money(-1), DepleteAbove: money(0), DepleteBelow: money(500), PageSize: 10, PageNum: 1},
+			want:      []string{"0004"},
+			wantTotal: 1,
+		},
+		{
+			name:      "先筛选排序再分页，总数为筛选后的数量",
+			q:         VccSummaryQuery{Sort: VccSortDeplete, DepleteAbove: money(0), PageSize: 2, PageNum: 2},
+			want:      []string{"0002"},
+			wantTotal: 3,
+		},
+		{
+			name:      "超出最后一页",
+			q:         VccSummaryQuery{PageSize: 2, PageNum: 4},
+			want:      []string{},
+			wantTotal: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, total := tt.q.page(summaries)
+			got := make([]string, len(rows))
+			for i, s := range rows {
+				got[i] = s.CardNumber
+			}
+			if total != tt.wantTotal || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("page() = %v %d, want %v %d", got, total, tt.want, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestGetVccSummariesInvalidQuery(t *testing.T) {
+	if _, _, err := GetVccSummaries("A", nil, 0, 0, time.UTC, "", VccSummaryQuery{Sort: "name", PageSize: 10, PageNum: 1}); !errors.Is(err, ErrInvalidVccSort) {
+		t.Errorf("invalid sort: err = %v, want ErrInvalidVccSort", err)
+	}
+	if _, _, err := GetVccSummaries("A", nil, 0, 0, time.UTC, "", VccSummaryQuery{PageSize: 0, PageNum: 1}); err == nil {
+		t.Error("zero page size: err = nil")
+	}
+}
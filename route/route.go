@@ -58,6 +58,24 @@ func InitRouter() {
 		auth.POST("user/add", v1.AddUser)
 		auth.GET("user/:id", v1.GetUserInfo)
 		auth.GET("users", v1.GetUsers)
+
+		// 回滚导入批次
+		auth.POST("importBatch/:id/rollback", v1.RollbackImportBatch)
+		// 维护汇率
+		auth.POST("fxRates/upload", v1.UploadFxRates)
+		auth.POST("fxRates", v1.AddFxRates)
+		// 修改余额规则
+		auth.PUT("ledgerRule", v1.EditLedgerRule)
+		// 人工匹配、撤销匹配
+		auth.POST("reconciliation", v1.AddReconciliation)
+		auth.DELETE("reconciliation/:id", v1.DeleteReconciliation)
+		// 重新核对未核对的账单
+		auth.POST("reconcile", v1.Reconcile)
+		// 重新关联退款、撤销、清算与交易授权
+		auth.POST("authorizations/relink", v1.RelinkAuthorizations)
+		// 结账、解锁（仅管理员）
+		auth.POST("periodLock", v1.LockPeriod)
+		auth.POST("periodLock/unlock", v1.UnlockPeriod)
 	}
 
 	router := r.Group("api/v1")
@@ -65,6 +83,41 @@ func InitRouter() {
 		// // 上传文件
 		router.POST("upload1", v1.Upload1)
 		router.POST("upload2", v1.Upload2)
+		// 导入预览：只解析文件，不写入数据库
+		router.POST("upload1/preview", v1.Preview1)
+		router.POST("upload2/preview", v1.Preview2)
+		// 可选的虚拟卡供应商
+		router.GET("cardProviders", v1.ShowCardProviders)
+		// 可选的广告平台
+		router.GET("billingPlatforms", v1.ShowBillingPlatforms)
+		// 导入任务进度
+		router.GET("importJobs", v1.ShowImportJobs)
+		router.GET("importJob/:id", v1.ShowImportJob)
+		// 导入批次
+		router.GET("importBatches", v1.ShowImportBatches)
+		router.GET("importBatch/:id", v1.ShowImportBatch)
+		// 交易在重新导入时的修改历史
+		router.GET("transaction/:id/history", v1.ShowTransactionHistory)
+		// 下载导入被拒绝的行
+		router.GET("importBatch/:id/rejects", v1.DownloadImportRejects)
+		// 汇率
+		router.GET("fxRates", v1.ShowFxRates)
+		// 账单与交易清算的匹配
+		router.GET("reconciliations", v1.ShowReconciliations)
+		router.GET("reconciliation/:id", v1.ShowReconciliation)
+		// 核对差异报表
+		router.GET("reconcileExceptions", v1.ShowReconcileExceptions)
+		router.GET("reconcileExceptions/export", v1.DownloadReconcileExceptions)
+		// 交易授权的净额、状态和没有原授权的退款
+		router.GET("authorizations", v1.ShowAuthorizations)
+		router.GET("authorization/:id", v1.ShowAuthorization)
+		router.GET("orphanRefunds", v1.ShowOrphanRefunds)
+		// 超期未清算的交易授权
+		router.GET("staleAuthorizations", v1.ShowStaleAuthorizations)
+		router.GET("staleAuthorizations/export", v1.DownloadStaleAuthorizations)
+		// 已结账的期间及结账记录
+		router.GET("periodLocks", v1.ShowPeriodLocks)
+		router.GET("periodLockEvents", v1.ShowPeriodLockEvents)
 		// 展示 FB 文件 没写完
 		router.GET("showvcc_record", v1.ShowFile1)
 		router.GET("showfb_record", v1.ShowFile2)
@@ -75,6 +128,10 @@ func InitRouter() {
 		// router.POST("showFBByaccountList", v1.ShowFBDataByaccountList)
 		// 查询所有虚拟卡的余额和总消耗
 		router.GET("showVccBalanceAndDeplete", v1.ShowVccBalanceAndDeplete)
+		// 一张卡的余额按余额规则的推导明细
+		router.GET("showVccBalanceBreakdown", v1.ShowVccBalanceBreakdown)
+		// 余额规则
+		router.GET("ledgerRules", v1.ShowLedgerRules)
 
 		// 需求2
 		router.POST("showfb_vccdata", v1.Showfb_vccdata)
@@ -90,7 +147,7 @@ func InitRouter() {
 
 		router.GET("showFBID", v1.ShowFBID)
 
-		// 1. 余额 ：开卡 + 充值 + 交易退款 + 交易授权撤销 - 交易授权 - 卡充退
+		// 1. 余额 ：按余额规则（ledgerRules）计算，默认为 开卡 + 充值、交易退款、交易授权、卡充退、交易授权撤销带符号的订单金额
 		// 2. 总消耗 ：交易授权
 		// router.POST("showVccBalance", v1.ShowVccBalance)
 		// router.POST("showVccDeplete", v1.ShowVccDeplete)
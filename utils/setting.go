@@ -21,6 +21,23 @@ var (
 	SecretKey  string
 	Bucket     string
 	QiniuSever string
+
+	BaseCurrency string
+
+	Timezone        string
+	SourceTimezones map[string]string
+
+	AmountTolerance string
+	DateWindowDays  int
+	MaxGroupSize    int
+
+	ReconcileInterval     int
+	ReconcileLookbackDays int
+
+	SettlementWindowDays int
+	RefundWindowDays     int
+
+	StaleAuthorizationDays int
 )
 
 // 初始化
@@ -28,10 +45,16 @@ func init() {
 	file, err := ini.Load("config/config.ini")
 	if err != nil {
 		fmt.Println("配置文件读取错误，请检查文件路径:", err)
+		// 使用默认配置，避免在没有配置文件的目录（如单元测试）中崩溃
+		file = ini.Empty()
 	}
 	LoadServer(file)
 	LoadData(file)
 	LoadQiniu(file)
+	LoadReport(file)
+	LoadTimezone(file)
+	LoadReconcile(file)
+	LoadAuthorization(file)
 }
 
 func LoadServer(file *ini.File) {
@@ -55,3 +78,32 @@ func LoadQiniu(file *ini.File) {
 	Bucket = file.Section("qiniu").Key("Bucket").String()
 	QiniuSever = file.Section("qiniu").Key("QiniuSever").String()
 }
+
+func LoadReport(file *ini.File) {
+	BaseCurrency = file.Section("report").Key("BaseCurrency").MustString("USD")
+}
+
+func LoadTimezone(file *ini.File) {
+	section := file.Section("timezone")
+	Timezone = section.Key("Default").MustString("Local")
+	SourceTimezones = make(map[string]string)
+	for _, key := range section.Keys() {
+		if key.Name() != "Default" {
+			SourceTimezones[key.Name()] = key.String()
+		}
+	}
+}
+
+func LoadReconcile(file *ini.File) {
+	AmountTolerance = file.Section("reconcile").Key("AmountTolerance").MustString("0.00")
+	DateWindowDays = file.Section("reconcile").Key("DateWindowDays").MustInt(7)
+	MaxGroupSize = file.Section("reconcile").Key("MaxGroupSize").MustInt(3)
+	ReconcileInterval = file.Section("reconcile").Key("ScheduleMinutes").MustInt(60)
+	ReconcileLookbackDays = file.Section("reconcile").Key("LookbackDays").MustInt(31)
+}
+
+func LoadAuthorization(file *ini.File) {
+	SettlementWindowDays = file.Section("authorization").Key("SettlementWindowDays").MustInt(30)
+	RefundWindowDays = file.Section("authorization").Key("RefundWindowDays").MustInt(180)
+	StaleAuthorizationDays = file.Section("authorization").Key("StaleDays").MustInt(14)
+}